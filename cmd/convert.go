@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// convert rewrites a recording in another storage format
+//
+//	stattrack convert <run-dir> --to parquet|csv|jsonl|sqlite [-o <output-dir>]
+func convert(args []string) int {
+
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stattrack convert <run-dir> --to parquet|csv|jsonl|sqlite [-o <output-dir>]")
+		flags.PrintDefaults()
+	}
+
+	formatPtr := flags.String("to", "", fmt.Sprintf("target format [%s]", strings.Join(persistence.ExportFormats, "|")))
+	outPtr := flags.String("o", "", "output directory (default: <run-dir>-<format>)")
+	logging := addLogFlags(flags)
+
+	dirs := parseArgs(flags, args)
+	if len(dirs) != 1 || !slices.Contains(persistence.ExportFormats, *formatPtr) {
+		flags.Usage()
+		return 2
+	}
+
+	logging.setup(os.Stderr)
+
+	outdir := *outPtr
+	if outdir == "" {
+		outdir = fmt.Sprintf("%s-%s", path.Clean(dirs[0]), *formatPtr)
+	}
+
+	ctx := context.Background()
+
+	rec, err := persistence.ReadRecording(ctx, dirs[0])
+	if err != nil {
+		slog.Error("could not read recording", "dir", dirs[0], "err", err)
+		return 1
+	}
+
+	err = persistence.WriteRecording(ctx, outdir, rec, *formatPtr)
+	if err != nil {
+		slog.Error("could not write recording", "dir", outdir, "err", err)
+		return 1
+	}
+
+	slog.Info("converted recording", "from", dirs[0], "to", outdir, "format", *formatPtr)
+
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/alert"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/monitor"
+	"golang.org/x/sys/unix"
+)
+
+// fileConfig is the content of the config file given with -c, SIGHUP reloads it
+type fileConfig struct {
+	Types measurements.MeasurementTypes `json:"types,omitempty"` // replace -m if set
+	Rules []alert.Rule                  `json:"-"`
+}
+
+// loadConfig reads a config file like
+//
+//	{"types": [0, 1, 5], "rules": [{"name": "busy", "when": "cpu.userp > 90 for 30s"}]}
+func loadConfig(file string) (fileConfig, error) {
+
+	var config fileConfig
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return config, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for _, mType := range config.Types {
+		if !mType.Valid() {
+			return config, fmt.Errorf("%s: %w: %d", file, measurements.ErrUnknownType, mType)
+		}
+	}
+
+	config.Rules, err = alert.LoadRules(file)
+
+	return config, err
+}
+
+// writePidfile writes the process ID to `file`, which is removed by the returned function
+func writePidfile(file string) (func(), error) {
+
+	err := os.WriteFile(file, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		err := os.Remove(file)
+		if err != nil {
+			slog.Error("could not remove pidfile", "file", file, "err", err)
+		}
+	}, nil
+}
+
+// sd_notify states, see https://www.freedesktop.org/software/systemd/man/sd_notify.html
+const (
+	notifyReady     = "READY=1"
+	notifyReloading = "RELOADING=1"
+	notifyStopping  = "STOPPING=1"
+	notifyWatchdog  = "WATCHDOG=1"
+)
+
+// notify tells systemd about the service's state if it was started with Type=notify,
+// without $NOTIFY_SOCKET it does nothing
+func notify(states ...string) {
+
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// sockets starting with @ are in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Debug("could not connect to notify socket", "socket", socket, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	var message []byte
+	for _, state := range states {
+		message = append(message, state+"\n"...)
+	}
+	_, err = conn.Write(message)
+	if err != nil {
+		slog.Debug("could not notify systemd", "err", err)
+	}
+}
+
+// notifyStatus is a free-form status shown by systemctl status
+func notifyStatus(status string) string {
+	return "STATUS=" + status
+}
+
+// notifyReloadingNow is RELOADING=1 with the timestamp systemd expects since version 253
+func notifyReloadingNow() []string {
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return []string{notifyReloading, fmt.Sprintf("MONOTONIC_USEC=%d", ts.Nano()/1000)}
+}
+
+// watchdog pings systemd's watchdog at half the interval given in $WATCHDOG_USEC until the context is cancelled.
+// It only pings while no monitor has gone without handling a tick for longer than `stall`,
+// so systemd restarts a stattrack whose collectors hang.
+func watchdog(ctx context.Context, stall time.Duration) {
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	// the watchdog may be meant for another process, e.g., if stattrack is started by a script
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	slog.Debug("pinging watchdog", "interval", interval, "stall", stall)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if stalled := monitor.Stalled(stall); len(stalled) > 0 {
+				slog.Warn("monitors stalled, not pinging watchdog", "types", stalled)
+				continue
+			}
+			notify(notifyWatchdog)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// metric is a single number summarizing one column of a recording
+type metric struct {
+	name      string
+	mType     measurements.MeasurementType
+	column    string
+	summarize func(values []float64) float64
+	tolerance *float64 // allowed increase in percent of the baseline
+	points    bool     // the tolerance is in percentage points instead, for columns that are percentages already
+}
+
+// diff compares two recordings and exits non-zero if the candidate uses more resources than allowed
+//
+//	stattrack diff [-cpu <pct>] [-mem <pct>] [-rx <pct>] [-tx <pct>] <baseline> <candidate>
+func diff(args []string) int {
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stattrack diff [-cpu <pct>] [-mem <pct>] [-rx <pct>] [-tx <pct>] <baseline> <candidate>")
+		fmt.Fprintln(flags.Output(), "exits with 1 if the candidate regressed, 2 on errors")
+		flags.PrintDefaults()
+	}
+
+	metrics := []metric{
+		{
+			name:      "mean cpu userp",
+			mType:     measurements.CPU,
+			column:    "userp",
+			summarize: mean,
+			tolerance: flags.Float64("cpu", 5, "allowed increase of the mean CPU user percentage in percentage points"),
+			points:    true,
+		},
+		{
+			name:      "peak memory used",
+			mType:     measurements.MEM,
+			column:    "used",
+			summarize: peak,
+			tolerance: flags.Float64("mem", 10, "allowed increase of the peak memory usage in percent"),
+		},
+		{
+			name:      "total network rx",
+			mType:     measurements.NET,
+			column:    "RxBytes",
+			summarize: total,
+			tolerance: flags.Float64("rx", 10, "allowed increase of the received bytes in percent"),
+		},
+		{
+			name:      "total network tx",
+			mType:     measurements.NET,
+			column:    "TxBytes",
+			summarize: total,
+			tolerance: flags.Float64("tx", 10, "allowed increase of the transmitted bytes in percent"),
+		},
+	}
+
+	logging := addLogFlags(flags)
+
+	dirs := parseArgs(flags, args)
+	if len(dirs) != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	logging.setup(os.Stderr)
+
+	ctx := context.Background()
+
+	baseline, err := persistence.ReadRecording(ctx, dirs[0])
+	if err != nil {
+		slog.Error("could not read baseline", "dir", dirs[0], "err", err)
+		return 2
+	}
+	candidate, err := persistence.ReadRecording(ctx, dirs[1])
+	if err != nil {
+		slog.Error("could not read candidate", "dir", dirs[1], "err", err)
+		return 2
+	}
+
+	regressed := false
+
+	fmt.Fprintf(os.Stdout, "%-18s %16s %16s %16s %9s\n", "metric", "baseline", "candidate", "delta", "delta %")
+
+	for _, m := range metrics {
+
+		b, bok := summary(baseline, m)
+		c, cok := summary(candidate, m)
+		if !bok || !cok {
+			// only metrics recorded in both runs can be compared
+			continue
+		}
+
+		delta := c - b
+		relative := math.Inf(1)
+		if b != 0 {
+			relative = delta / math.Abs(b) * 100
+		} else if delta == 0 {
+			relative = 0
+		}
+
+		increase, unit := relative, "%"
+		if m.points {
+			increase, unit = delta, " points"
+		}
+
+		line := fmt.Sprintf("%-18s %16.2f %16.2f %+16.2f %+8.2f%%", m.name, b, c, delta, relative)
+		if increase > *m.tolerance {
+			regressed = true
+			line = color.RedString("%s  regression (tolerance %.2f%s)", line, *m.tolerance, unit)
+		}
+		fmt.Fprintln(os.Stdout, line)
+	}
+
+	if regressed {
+		return 1
+	}
+
+	return 0
+}
+
+// summary computes a metric for a recording, false if the recording lacks the column
+func summary(rec *persistence.Recording, m metric) (float64, bool) {
+
+	for _, table := range rec.Tables {
+
+		if table.Type != m.mType {
+			continue
+		}
+
+		column := table.Column(m.column)
+		if column < 0 {
+			return 0, false
+		}
+
+		var values []float64
+		for _, row := range table.Rows {
+			value, err := strconv.ParseFloat(row[column], 64)
+			if err != nil || math.IsNaN(value) {
+				continue
+			}
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			return 0, false
+		}
+
+		return m.summarize(values), true
+	}
+
+	return 0, false
+}
+
+func mean(values []float64) float64 {
+	return total(values) / float64(len(values))
+}
+
+func peak(values []float64) float64 {
+	res := math.Inf(-1)
+	for _, value := range values {
+		res = math.Max(res, value)
+	}
+	return res
+}
+
+func total(values []float64) float64 {
+	var res float64
+	for _, value := range values {
+		res += value
+	}
+	return res
+}
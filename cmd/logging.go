@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+)
+
+// logOptions are the logging flags shared by all commands
+type logOptions struct {
+	level   slog.Level
+	verbose bool
+	json    bool
+}
+
+func addLogFlags(flags *flag.FlagSet) *logOptions {
+
+	o := &logOptions{level: slog.LevelInfo}
+
+	flags.TextVar(&o.level, "log-level", o.level, "log level [debug|info|warn|error]")
+	flags.BoolVar(&o.verbose, "v", false, "verbose logging, same as -log-level debug")
+	flags.BoolVar(&o.json, "log-json", false, "write the log as JSON")
+
+	return o
+}
+
+// setup makes a logger writing to `w` the default logger of all packages
+func (o *logOptions) setup(w io.Writer) {
+
+	level := o.level
+	if o.verbose {
+		level = slog.LevelDebug
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if o.json {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
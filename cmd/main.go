@@ -4,165 +4,667 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/VividCortex/multitick"
-	"github.com/fatih/color"
 	"github.com/google/uuid"
+	"github.com/valentin-carl/stattrack/pkg/alert"
+	"github.com/valentin-carl/stattrack/pkg/dashboard"
 	"github.com/valentin-carl/stattrack/pkg/measurements"
 	"github.com/valentin-carl/stattrack/pkg/monitor"
 	"github.com/valentin-carl/stattrack/pkg/persistence"
 )
 
+// time between two measurements
+const interval = time.Second
+
+// a monitor that hasn't handled a tick for this long is considered stalled by the watchdog
+const stallTimeout = 10 * interval
+
+// subcommands, `stattrack` without one records measurements
+var commands = map[string]func(args []string) int{
+	"convert": convert,
+	"merge":   merge,
+	"diff":    diff,
+	"agent":   agent,
+	"server":  server,
+}
+
 func main() {
 
-	log.Println("stattrack started")
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	os.Exit(record())
+}
+
+// record records measurements until the duration is over or a stop condition is met and returns the exit code,
+// deferred cleanup like removing the pidfile runs before main exits
+func record() int {
 
 	// read command line flags
 	var types measurements.MeasurementTypes
-	flag.Var(&types, "m", "measurement type [0=cpu|1=mem|2=net]. Can occur multiple times for measuring different stats simultaneously.")
+	flag.Var(&types, "m", "measurement type [0=cpu|1=mem|2=net|3=tcp/udp|4=load|5=pressure|6=filesystems|7=cgroups|8=limits|9=extended memory|10=sensors]. Can occur multiple times for measuring different stats simultaneously.")
 
-	durationPtr := flag.Int("t", -1, "measurement duration in seconds")
+	var retentions persistence.Retentions
+	flag.Var(&retentions, "retention", "sqlite retention per measurement type as <type>:<raw>[:<minute>[:<hour>]], e.g., 0:1h:24h. Can occur multiple times.")
+
+	durationPtr := flag.Int("t", -1, "measurement duration in seconds, unlimited if not positive")
 	formatPtr := flag.String("o", "csv", "output format [csv|sqlite]")
 	directoryPtr := flag.String("d", ".", "output directory")
+	databasePtr := flag.String("db", "", "shared sqlite database; with -o sqlite, the run is added to this database instead of a new data.db")
+	hostPtr := flag.String("host", hostname(), "host name stored with the run in a shared database")
+	monitoring := addMonitorFlags(flag.CommandLine)
+
+	configPtr := flag.String("c", "", "config file with alerting rules and the types to record, e.g., {\"types\": [0, 1], \"rules\": [{\"name\": \"cpu\", \"when\": \"cpu.userp > 90 for 30s\", \"command\": \"...\"}]}. SIGHUP reloads it.")
+	var stop stopConditions
+	var stopWhen stringList
+	flag.StringVar(&stop.file, "stop-file", "", "stop recording once this file exists")
+	flag.IntVar(&stop.pid, "stop-pid", 0, "stop recording once the process with this PID has exited")
+	flag.Var(&stop.maxSize, "max-size", "stop recording once the output is at least this large, e.g., 500M or 2G")
+	flag.Var(&stopWhen, "stop-when", "stop recording once a condition like 'cpu.idlep > 95 for 10s' holds. Can occur multiple times.")
+
+	var start startConditions
+	flag.StringVar(&start.at, "start-at", "", "only start recording at this time, e.g., 22:00 or 2024-01-31T22:00:00+01:00")
+	flag.BoolVar(&start.signal, "start-signal", false, "only start recording on SIGUSR1. Otherwise, SIGUSR1 only flushes the output files, it doesn't rotate them.")
+	flag.BoolVar(&start.command, "start-command", false, "only start recording on the start command of the control socket")
+	flag.Var(&start.when, "start-when", "only start recording once a condition like 'cpu.userp > 50' holds. Can occur multiple times.")
+	flag.DurationVar(&start.pretrigger, "pretrigger", 0, "when the recording starts on a trigger, also keep the measurements of this long before it, e.g., 30s")
+	controlPtr := flag.String("control", "", "unix socket accepting the commands start and stop, e.g., stattrack.sock")
+
+	pidfilePtr := flag.String("pidfile", "", "write the process ID to this file while recording, e.g., to send SIGHUP (reload -c) or SIGUSR1 (flush the output, no rotation)")
+
+	tuiPtr := flag.Bool("tui", false, "show a live dashboard instead of the log, which is written to <output directory>/stattrack.log")
+	logging := addLogFlags(flag.CommandLine)
 
 	flag.Parse() // ends the program if input is invalid
 
-	log.Printf("%d %s %s", *durationPtr, *formatPtr, *directoryPtr)
-	log.Println(types)
+	logging.setup(os.Stderr)
+
+	slog.Info("stattrack started", "types", types, "duration", *durationPtr, "format", *formatPtr, "dir", *directoryPtr)
+
+	if *formatPtr != "csv" && *formatPtr != "sqlite" {
+		slog.Error("invalid output format", "format", *formatPtr)
+		return 2
+	}
+
+	// the config file can replace -m and adds alerting rules
+	flagTypes := slices.Clone(types)
+	types, file, err := readConfig(*configPtr, types)
+	if err != nil {
+		slog.Error("invalid config", "err", err)
+		return 2
+	}
+
+	// metric stop conditions are alerting rules that stop the recording
+	var stopRules []alert.Rule
+	for _, when := range stopWhen {
+		rule, err := alert.ParseRule(when, when)
+		if err != nil {
+			slog.Error("invalid stop condition", "err", err)
+			return 2
+		}
+		rule.Stop = true
+		stopRules = append(stopRules, rule)
+	}
+
+	rules := append(slices.Clone(file.Rules), stopRules...)
+	checkRules(rules, types)
+
+	// metric start conditions are rules, too, but they open the gate in front of the backends
+	var startRules []alert.Rule
+	for _, when := range start.when {
+		rule, err := alert.ParseRule(when, when)
+		if err != nil {
+			slog.Error("invalid start condition", "err", err)
+			return 2
+		}
+		rule.Stop = true
+		startRules = append(startRules, rule)
+	}
+
+	var startAt <-chan time.Time
+	if start.at != "" {
+		at, err := parseStartTime(start.at, time.Now())
+		if err != nil {
+			slog.Error("invalid start condition", "err", err)
+			return 2
+		}
+		startAt = time.After(time.Until(at))
+		slog.Info("recording starts at", "time", at)
+	}
+
+	if start.command && *controlPtr == "" {
+		slog.Error("-start-command requires a control socket, see -control")
+		return 2
+	}
+
+	monitoring.configure()
 
 	// these tell the main goroutine when it's time to stop
-	timer := time.NewTimer(time.Duration(*durationPtr) * time.Second)
-	interrupt := make(chan os.Signal)
-	signal.Notify(interrupt, os.Interrupt)
+	var timeout <-chan time.Time // without a duration, the recording runs until another condition stops it
+	if *durationPtr > 0 && !start.armed() {
+		timeout = time.After(time.Duration(*durationPtr) * time.Second)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	// SIGHUP reloads the config file, SIGUSR1 starts the recording with -start-signal and otherwise only flushes the backends,
+	// the files stay open, so there is no rotation
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
 
 	// this tells the monitors when it's time to stop
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if *pidfilePtr != "" {
+		removePidfile, err := writePidfile(*pidfilePtr)
+		if err != nil {
+			slog.Error("cannot write pidfile", "err", err)
+			return 1
+		}
+		defer removePidfile()
+	}
 
 	/* create the backends */
-	var err error
 
-	backends := make(map[measurements.MeasurementType]persistence.Backend)
-	channels := make(map[measurements.MeasurementType]chan measurements.Measurement)
-	outdir := fmt.Sprintf("%s-%s", "./output", uuid.New().String())
+	run := persistence.Run{
+		ID:      uuid.New().String(),
+		Host:    *hostPtr,
+		Started: time.Now(),
+	}
+	outdir := fmt.Sprintf("%s-%s", "./output", run.ID)
 	outdir = path.Join(*directoryPtr, outdir)
 
-	log.Println(color.GreenString(outdir))
-
-	// create the backends
-	switch *formatPtr {
-	case "csv":
-		{
-			for _, mType := range types {
-
-				mType := measurements.MeasurementType(mType)
+	// the dashboard takes over the terminal, so the log has to go somewhere else
+	if *tuiPtr {
+		logfile, err := createLogFile(outdir)
+		if err != nil {
+			slog.Error("cannot create log file for dashboard mode", "err", err)
+			return 1
+		}
+		defer logfile.Close()
+		logging.setup(logfile)
+	}
 
-				log.Println("MEASUREMENT TYPE", mType)
+	slog.Info("recording", "run", run.ID, "outdir", outdir)
 
-				// channel through which monitor and backend communicate
-				channels[mType] = make(chan measurements.Measurement)
+	// writeRun stores the run's metadata in the output directory or the shared database's runs table
+	writeRun := func() {
+		var err error
+		if *databasePtr != "" {
+			err = persistence.WriteSharedRun(context.Background(), *databasePtr, run)
+		} else {
+			err = persistence.WriteRun(outdir, run)
+		}
+		if err != nil {
+			slog.Error("could not write run metadata", "err", err)
+		}
+	}
+	writeRun()
 
-				// backend
-				backends[mType], err = persistence.NewCSVBackend(
-					ctx,
-					channels[mType],
-					outdir,
-					mType,
-				)
-				if err != nil {
-					log.Panicln("cannot create CSV backend for measurement type", mType)
-				}
+	// newBackend creates the backend for one measurement type in the requested format
+	newBackend := func(ctx context.Context, mType measurements.MeasurementType, values <-chan measurements.Measurement) (persistence.Backend, error) {
+		switch {
+		case *formatPtr == "csv":
+			return persistence.NewCSVBackend(ctx, values, outdir, mType)
+		case *databasePtr != "":
+			return persistence.NewSharedSqliteBackend(ctx, values, *databasePtr, mType, run)
+		default:
+			backend, err := persistence.NewSqliteBackend(ctx, values, outdir, mType, "data.db")
+			if err != nil {
+				return nil, err
 			}
+			backend.SetRetention(retentions[mType])
+			return backend, nil
 		}
-	case "sqlite":
-		{
-			for _, mType := range types {
+	}
 
-				log.Println("MEASUREMENT TYPE", mType)
+	// wait group for everything but the pipelines below, which have their own
+	var wg sync.WaitGroup
 
-				// channel through which monitor and backend communicate
-				channels[mType] = make(chan measurements.Measurement)
+	// the backends of the internal types, they're flushed along with the others
+	var internal []persistence.Backend
 
-				// backend
-				backends[mType], err = persistence.NewSqliteBackend(
-					ctx,
-					channels[mType],
-					outdir,
-					mType,
-					"data.db",
-				)
-				if err != nil {
-					log.Panicln("cannot create CSV backend for measurement type", mType)
-				}
-			}
-		}
-	default:
-		{
-			log.Panicf("didn't get valid output format %s\n", *formatPtr)
-		}
+	// the gaps of all monitors are written by one additional backend
+	gaps := make(chan measurements.Measurement)
+	gapBackend, err := newBackend(ctx, measurements.GAP, gaps)
+	if err != nil {
+		slog.Error("cannot create backend for gaps, gaps are only logged", "err", err)
+		gaps = nil
+	} else {
+		internal = append(internal, gapBackend)
+		wg.Add(1)
+		go func() {
+			gapBackend.Start()
+			wg.Done()
+		}()
 	}
 
-	// wait group for both, monitors and backends
-	var wg sync.WaitGroup
+	/* start the dashboard and the alerting */
+
+	// the monitors' values are copied to the dashboard and the alerting on their way to the backends
+	var taps []chan<- measurements.Measurement
 
-	/* start the backends */
+	if *tuiPtr {
+		values := make(chan measurements.Measurement, 64)
+		taps = append(taps, values)
 
-	for i := range types {
-		i := i
+		wg.Add(1)
 		go func() {
-			log.Println("starting backend for type", types[i], i)
+			dashboard.NewDashboard(values, os.Stdout, outdir).Start(ctx)
+			wg.Done()
+		}()
+	}
+
+	var stopRule <-chan string // receives the name of a rule that stops the recording
+	var reloadAlerting func(rules []alert.Rule)
+	if *configPtr != "" || len(rules) > 0 {
+
+		// the alerts are written by one additional backend
+		alerts := make(chan measurements.Measurement)
+		alertBackend, err := newBackend(ctx, measurements.ALERT, alerts)
+		if err != nil {
+			slog.Error("cannot create backend for alerts, alerts are only logged", "err", err)
+			alerts = nil
+		} else {
+			internal = append(internal, alertBackend)
 			wg.Add(1)
-			backends[measurements.MeasurementType(types[i])].Start()
+			go func() {
+				alertBackend.Start()
+				wg.Done()
+			}()
+		}
+
+		values := make(chan measurements.Measurement, 64)
+		taps = append(taps, values)
+
+		// a reload replaces the engine's rules, alerts of unchanged rules keep their state
+		engine := alert.NewEngine(rules, run.Host, alerts)
+		stopRule = engine.Stop()
+		reloadAlerting = engine.Reload
+
+		wg.Add(1)
+		go func() {
+			engine.Start(ctx, values)
 			wg.Done()
-			log.Println("goroutine for backend for type", i, "is done")
 		}()
 	}
 
-	/* start the monitors */
+	/* arm the trigger */
+
+	// until the recording is triggered, a gate in front of each backend holds back the measurements
+	open := make(chan struct{})
+	monitorGaps := gaps
+	if start.armed() && gaps != nil {
+		monitorGaps = make(chan measurements.Measurement)
+		go monitor.Gate(ctx, monitorGaps, gaps, open, start.pretrigger)
+	}
+
+	var startRule <-chan string // receives the name of the start condition that holds
+	if len(startRules) > 0 {
+
+		values := make(chan measurements.Measurement, 64)
+		taps = append(taps, values)
 
-	var ticker = multitick.NewTicker(time.Second, 0)
+		trigger := alert.NewEngine(startRules, run.Host, nil)
+		startRule = trigger.Stop()
 
-	for i := range types {
-		i := i
+		wg.Add(1)
 		go func() {
-			log.Println("starting monitor for type", types[i])
-			wg.Add(1)
-			mType := measurements.MeasurementType(types[i])
-			monitor.Monitor(ctx, ticker.Subscribe(), channels[mType], mType)
-			log.Printf("monitor %d: calling `wg.Done()`\n", i)
+			trigger.Start(ctx, values)
 			wg.Done()
 		}()
 	}
 
+	var commands <-chan string
+	if *controlPtr != "" {
+		commands, err = listenControl(ctx, *controlPtr)
+		if err != nil {
+			slog.Error("cannot listen on control socket", "socket", *controlPtr, "err", err)
+			return 1
+		}
+	}
+
+	// triggered opens the gates, the duration of the recording starts now
+	waiting := start.armed()
+	triggered := func(trigger string) {
+		if !waiting {
+			return
+		}
+		waiting = false
+		close(open)
+
+		now := time.Now()
+		run.Trigger, run.Triggered = trigger, &now
+		writeRun()
+
+		if *durationPtr > 0 {
+			timeout = time.After(time.Duration(*durationPtr) * time.Second)
+		}
+		slog.Info("triggered, recording ...", "trigger", trigger, "pretrigger", start.pretrigger)
+		notify(notifyStatus("recording"))
+	}
+
+	/* start the pipelines */
+
+	// each recorded type has a pipeline of monitor, tee, gate and backend with its own context,
+	// so a reload can add and remove types while the others keep recording
+	type pipeline struct {
+		backend persistence.Backend
+		cancel  context.CancelFunc
+		done    sync.WaitGroup
+	}
+	pipelines := make(map[measurements.MeasurementType]*pipeline)
+	var recorded measurements.MeasurementTypes // every type recorded at some point
+
+	var ticker = multitick.NewTicker(interval, 0)
+
+	startPipeline := func(mType measurements.MeasurementType) error {
+
+		pctx, cancel := context.WithCancel(ctx)
+
+		// channel through which monitor and backend communicate
+		values := make(chan measurements.Measurement)
+
+		backend, err := newBackend(pctx, mType, values)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		p := &pipeline{backend: backend, cancel: cancel}
+		pipelines[mType] = p
+		if !slices.Contains(recorded, mType) {
+			recorded = append(recorded, mType)
+		}
+
+		p.done.Add(1)
+		go func() {
+			slog.Debug("starting backend", "type", mType)
+			backend.Start()
+			p.done.Done()
+			slog.Debug("goroutine for backend is done", "type", mType)
+		}()
+
+		sink := values
+		if start.armed() {
+			sink = make(chan measurements.Measurement)
+			go monitor.Gate(pctx, sink, values, open, start.pretrigger)
+		}
+
+		source := sink
+		if len(taps) > 0 {
+			source = make(chan measurements.Measurement)
+			go monitor.Tee(pctx, source, sink, taps...)
+		}
+
+		// the ticker can't unsubscribe a removed monitor, but it doesn't block on channels nobody reads
+		p.done.Add(1)
+		go func() {
+			slog.Debug("starting monitor", "type", mType)
+			monitor.Monitor(pctx, ticker.Subscribe(), source, monitorGaps, mType, interval)
+			slog.Debug("goroutine for monitor is done", "type", mType)
+			p.done.Done()
+		}()
+
+		return nil
+	}
+
+	// stopPipeline waits until the type's monitor and backend are done, so its output is complete
+	stopPipeline := func(mType measurements.MeasurementType) {
+		p := pipelines[mType]
+		p.cancel()
+		p.done.Wait()
+		delete(pipelines, mType)
+	}
+
+	for _, mType := range types {
+		err = startPipeline(mType)
+		if err != nil {
+			slog.Error("cannot create backend, not recording this type", "type", mType, "format", *formatPtr, "err", err)
+		}
+	}
+
+	// the types whose backend couldn't be created are left out, the others are recorded anyway
+	if len(pipelines) == 0 {
+		slog.Error("no backend could be created, quitting")
+		return 1
+	}
+
+	// the output to check against -max-size
+	if *databasePtr != "" {
+		stop.output = []string{*databasePtr, *databasePtr + "-wal"}
+	} else {
+		stop.output = []string{outdir}
+	}
+	stopped := stop.watch(ctx)
+
+	// reload applies the config file again: added types are started, removed ones are stopped
+	// and the alerting rules are replaced, the other types keep recording
+	reload := func() {
+
+		if *configPtr == "" {
+			slog.Warn("no config file to reload, see -c")
+			return
+		}
+		file, err := loadConfig(*configPtr)
+		if err != nil {
+			slog.Error("could not reload config, keeping the current one", "err", err)
+			return
+		}
+
+		notify(notifyReloadingNow()...)
+		defer notify(notifyReady)
+
+		wanted := flagTypes
+		if len(file.Types) > 0 {
+			wanted = file.Types
+		}
+
+		for mType := range pipelines {
+			if !slices.Contains(wanted, mType) {
+				slog.Info("no longer recording", "type", mType)
+				stopPipeline(mType)
+			}
+		}
+		for _, mType := range wanted {
+			if _, ok := pipelines[mType]; ok {
+				continue
+			}
+			err := startPipeline(mType)
+			if err != nil {
+				slog.Error("cannot create backend, not recording this type", "type", mType, "format", *formatPtr, "err", err)
+				continue
+			}
+			slog.Info("now recording", "type", mType)
+		}
+
+		rules := append(slices.Clone(file.Rules), stopRules...)
+		checkRules(rules, wanted)
+		reloadAlerting(rules)
+
+		slog.Info("config reloaded", "config", *configPtr, "types", len(pipelines), "rules", len(rules))
+	}
+
+	// flush writes the values buffered by the backends
+	flush := func() {
+		backends := slices.Clone(internal)
+		for _, p := range pipelines {
+			backends = append(backends, p.backend)
+		}
+		for _, backend := range backends {
+			if flusher, ok := backend.(persistence.Flusher); ok {
+				err := flusher.Flush()
+				if err != nil {
+					slog.Error("could not flush backend", "err", err)
+				}
+			}
+		}
+		slog.Info("backends flushed")
+	}
+
+	// systemd is told once everything runs
+	if waiting {
+		slog.Info("waiting for trigger")
+		notify(notifyReady, notifyStatus("waiting for trigger"))
+	} else {
+		notify(notifyReady, notifyStatus("recording"))
+	}
+	go watchdog(ctx, stallTimeout)
+
 	// wait for timer/interrupt
 	// and cancel the context
-	log.Println("main goroutine waiting for interrupt or timer to end")
+	slog.Debug("main goroutine waiting for interrupt or timer to end")
 	for {
 		select {
-		case <-timer.C:
+		case <-timeout:
+			{
+				slog.Info("timer over, quitting ...")
+				run.StopReason = "duration"
+				goto TheFinishLine
+			}
+		case sig := <-interrupt:
 			{
-				log.Println("timer over, quitting ...")
+				slog.Info("interrupted, quitting ...", "signal", sig)
+				run.StopReason = "interrupt"
 				goto TheFinishLine
 			}
-		case <-interrupt:
+		case rule := <-stopRule:
 			{
-				log.Println("main goroutine interrupted, quitting ...")
+				slog.Info("alert stopped the recording, quitting ...", "rule", rule)
+				run.StopReason = fmt.Sprintf("rule %s fired", rule)
+				goto TheFinishLine
+			}
+		case <-startAt:
+			{
+				triggered("start time")
+			}
+		case <-usr1:
+			{
+				if waiting && start.signal {
+					triggered("signal")
+				} else {
+					flush()
+				}
+			}
+		case <-hangup:
+			{
+				reload()
+			}
+		case rule := <-startRule:
+			{
+				triggered(fmt.Sprintf("rule %s fired", rule))
+			}
+		case command := <-commands:
+			{
+				switch command {
+				case commandStart:
+					triggered("control socket")
+				case commandStop:
+					slog.Info("stop command received, quitting ...")
+					run.StopReason = "control socket"
+					goto TheFinishLine
+				}
+			}
+		case reason := <-stopped:
+			{
+				slog.Info("stop condition met, quitting ...", "reason", reason)
+				run.StopReason = reason
 				goto TheFinishLine
 			}
 		}
 	}
 
 TheFinishLine:
-	log.Println("stopping monitors ...")
+	slog.Info("stopping monitors ...")
+	notify(notifyStopping)
 	cancel()
+	for _, p := range pipelines {
+		p.done.Wait()
+	}
 	wg.Wait() // waits until all monitors & backends are done
 
+	// failed collections are gaps in the data, the run's metadata tells how many there were
+	run.Errors = collectionErrors(recorded)
+	writeRun()
+
 	// program over :-)
-	log.Println("thank you for recording your os stats with deutsche bahn")
+	slog.Info("thank you for recording your os stats with deutsche bahn")
+
+	return 0
+}
+
+// checkRules warns about rules that refer to a measurement type that isn't recorded, they never fire
+func checkRules(rules []alert.Rule, types measurements.MeasurementTypes) {
+	for _, rule := range rules {
+		if !slices.Contains(types, rule.Type()) {
+			slog.Warn("alerting rule refers to a measurement type that isn't recorded", "rule", rule.Name, "type", rule.Type())
+		}
+	}
+}
+
+// hostname returns the machine's host name or "localhost" if it cannot be determined
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
+// stringList collects the values of a flag that can occur multiple times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseArgs parses flags that may come before, after or in between positional arguments
+// and returns the positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+
+	var positional []string
+
+	for {
+		flags.Parse(args) // exits on invalid input
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// createLogFile creates the output directory and a log file in it
+func createLogFile(outdir string) (*os.File, error) {
+
+	err := os.MkdirAll(outdir, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Create(path.Join(outdir, "stattrack.log"))
 }
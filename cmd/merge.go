@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// merge combines the recordings of several hosts into one time-aligned dataset
+//
+//	stattrack merge [-i <interval>] [--to csv|jsonl|parquet|sqlite] [-o <output-dir>] <run-dir>...
+func merge(args []string) int {
+
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stattrack merge [-i <interval>] [--to csv|jsonl|parquet|sqlite] [-o <output-dir>] <run-dir>...")
+		flags.PrintDefaults()
+	}
+
+	intervalPtr := flags.Duration("i", time.Second, "interval of the common time grid, whole seconds")
+	formatPtr := flags.String("to", "csv", fmt.Sprintf("output format [%s]", strings.Join(persistence.ExportFormats, "|")))
+	outPtr := flags.String("o", "", "output directory (default: ./merged-<uuid>)")
+	logging := addLogFlags(flags)
+
+	dirs := parseArgs(flags, args)
+	if len(dirs) == 0 || !slices.Contains(persistence.ExportFormats, *formatPtr) {
+		flags.Usage()
+		return 2
+	}
+
+	logging.setup(os.Stderr)
+
+	ctx := context.Background()
+
+	var recs []*persistence.Recording
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+
+		rec, err := persistence.ReadRecording(ctx, dir)
+		if err != nil {
+			slog.Error("could not read recording", "dir", dir, "err", err)
+			return 1
+		}
+
+		// older recordings don't know their host
+		if rec.Run.Host == "" {
+			rec.Run.Host = path.Base(path.Clean(dir))
+		}
+
+		// several runs of the same host must not be averaged together
+		if seen[rec.Run.Host] {
+			rec.Run.Host = fmt.Sprintf("%s-%.8s", rec.Run.Host, rec.Run.ID)
+		}
+		seen[rec.Run.Host] = true
+
+		recs = append(recs, rec)
+	}
+
+	merged, err := persistence.MergeRecordings(recs, *intervalPtr)
+	if err != nil {
+		slog.Error("could not merge recordings", "err", err)
+		return 2
+	}
+
+	outdir := *outPtr
+	if outdir == "" {
+		outdir = fmt.Sprintf("./merged-%s", merged.Run.ID)
+	}
+
+	err = persistence.WriteRecording(ctx, outdir, merged, *formatPtr)
+	if err != nil {
+		slog.Error("could not write merged recording", "dir", outdir, "err", err)
+		return 1
+	}
+
+	slog.Info("merged recordings", "recordings", len(recs), "to", outdir, "format", *formatPtr)
+
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/monitor"
+)
+
+// monitorOptions are the flags configuring the collectors, shared by stattrack and the agent
+type monitorOptions struct {
+	procRoot, sysRoot                                  string
+	netInclude, netExclude                             monitor.Patterns
+	fsInclude, fsExclude, fsTypeInclude, fsTypeExclude monitor.Patterns
+	cgroups, cgroupParents                             stringList
+}
+
+func addMonitorFlags(flags *flag.FlagSet) *monitorOptions {
+
+	o := &monitorOptions{}
+
+	flags.StringVar(&o.procRoot, "proc-root", "/proc", "where procfs is mounted, e.g., /host/proc to record the host from a container")
+	flags.StringVar(&o.sysRoot, "sys-root", "/sys", "where sysfs is mounted, e.g., /host/sys")
+
+	flags.Var(&o.netInclude, "net-include", "only record network interfaces matching this pattern, e.g., 'eth*'. Can occur multiple times.")
+	flags.Var(&o.netExclude, "net-exclude", "don't record network interfaces matching this pattern, e.g., 'veth*'. Replaces the default, lo. Can occur multiple times.")
+
+	flags.Var(&o.fsInclude, "fs-include", "only record filesystems mounted at a path matching this pattern, e.g., '/mnt/*'. Can occur multiple times.")
+	flags.Var(&o.fsExclude, "fs-exclude", "don't record filesystems mounted at a path matching this pattern. Can occur multiple times.")
+	flags.Var(&o.fsTypeInclude, "fs-type-include", "only record filesystems of a type matching this pattern, e.g., ext4. Can occur multiple times.")
+	flags.Var(&o.fsTypeExclude, "fs-type-exclude", "don't record filesystems of a type matching this pattern, e.g., tmpfs. Can occur multiple times.")
+
+	flags.Var(&o.cgroups, "cgroup", "record this cgroup, relative to the cgroup root, e.g., /system.slice/docker.service. Can occur multiple times.")
+	flags.Var(&o.cgroupParents, "cgroup-children", "record all children of this cgroup (default /). Can occur multiple times.")
+
+	return o
+}
+
+// configure makes the collectors of all monitors use the options
+func (o *monitorOptions) configure() {
+
+	config := monitor.DefaultConfig()
+	config.ProcRoot = o.procRoot
+	config.SysRoot = o.sysRoot
+	if len(o.netInclude) > 0 {
+		config.Interfaces.Include = o.netInclude
+	}
+	if len(o.netExclude) > 0 {
+		config.Interfaces.Exclude = o.netExclude
+	}
+	config.Mountpoints = monitor.Filter{Include: o.fsInclude, Exclude: o.fsExclude}
+	config.FSTypes = monitor.Filter{Include: o.fsTypeInclude, Exclude: o.fsTypeExclude}
+	if len(o.cgroups) > 0 || len(o.cgroupParents) > 0 {
+		config.Cgroups = o.cgroups
+		config.CgroupParents = o.cgroupParents
+	}
+
+	monitor.Configure(config)
+}
+
+// readConfig reads the config file given with -c, if any, and returns the types to record:
+// those listed in the file, otherwise those given with -m
+func readConfig(file string, types measurements.MeasurementTypes) (measurements.MeasurementTypes, fileConfig, error) {
+
+	if file == "" {
+		return types, fileConfig{}, nil
+	}
+
+	config, err := loadConfig(file)
+	if err != nil {
+		return nil, config, err
+	}
+	if len(config.Types) > 0 {
+		types = config.Types
+	}
+
+	return types, config, nil
+}
+
+// collectionErrors logs the failed collections of each type and returns their numbers for the run's metadata,
+// nil if there weren't any
+func collectionErrors(types []measurements.MeasurementType) map[string]uint64 {
+
+	var errors map[string]uint64
+
+	for _, mType := range types {
+		if n := monitor.Errors(mType); n > 0 {
+			name, _ := measurements.GetFileName(mType)
+			if errors == nil {
+				errors = make(map[string]uint64)
+			}
+			errors[name] = n
+			slog.Warn("some measurements could not be collected", "type", mType, "errors", n)
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/VividCortex/multitick"
+	"github.com/google/uuid"
+	"github.com/valentin-carl/stattrack/pkg/alert"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/monitor"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+	"github.com/valentin-carl/stattrack/pkg/remote"
+)
+
+// agent records measurements like `stattrack` but sends them to a server instead of writing them
+//
+//	stattrack agent -server <host:port> [-m <type>]... [-c <file>] [-t <seconds>] [-host <name>] [-spool <dir>] [-tls] [-ca <file>] [-cert <file> -key <file>]
+func agent(args []string) int {
+
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stattrack agent -server <host:port> [-m <type>]... [-c <file>] [-t <seconds>] [-host <name>] [-spool <dir>] [-tls] [-ca <file>] [-cert <file> -key <file>]")
+		flags.PrintDefaults()
+	}
+
+	var types measurements.MeasurementTypes
+	flags.Var(&types, "m", "measurement type, see stattrack -h. Can occur multiple times.")
+
+	serverPtr := flags.String("server", "", "address of the server, e.g., collector:7070")
+	durationPtr := flags.Int("t", -1, "measurement duration in seconds, unlimited if not positive")
+	hostPtr := flags.String("host", hostname(), "host name the server stores the run with")
+	spoolPtr := flags.String("spool", "spool", "directory for the measurements that couldn't be sent yet")
+	tlsPtr := flags.Bool("tls", false, "connect with TLS")
+	caPtr := flags.String("ca", "", "verify the server's certificate with this CA certificate instead of the system's, implies -tls")
+	certPtr := flags.String("cert", "", "authenticate to the server with this client certificate, see server -tls-ca, implies -tls")
+	keyPtr := flags.String("key", "", "the client certificate's private key")
+	configPtr := flags.String("c", "", "config file with alerting rules and the types to record, see stattrack -h. It's read once at start, the alerts are only logged.")
+	monitoring := addMonitorFlags(flags)
+	logging := addLogFlags(flags)
+
+	flags.Parse(args)
+
+	logging.setup(os.Stderr)
+
+	types, file, err := readConfig(*configPtr, types)
+	if err != nil {
+		slog.Error("invalid config", "err", err)
+		return 2
+	}
+	if *serverPtr == "" || len(types) == 0 || (*certPtr == "") != (*keyPtr == "") {
+		flags.Usage()
+		return 2
+	}
+	checkRules(file.Rules, types)
+
+	var tlsConfig *tls.Config
+	if *tlsPtr || *caPtr != "" || *certPtr != "" {
+		tlsConfig = &tls.Config{}
+	}
+	if *caPtr != "" {
+		tlsConfig.RootCAs, err = certPool(*caPtr)
+		if err != nil {
+			slog.Error("cannot read CA certificate", "err", err)
+			return 1
+		}
+	}
+	if *certPtr != "" {
+		cert, err := tls.LoadX509KeyPair(*certPtr, *keyPtr)
+		if err != nil {
+			slog.Error("cannot load client certificate", "err", err)
+			return 1
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	monitoring.configure()
+
+	run := persistence.Run{
+		ID:      uuid.New().String(),
+		Host:    *hostPtr,
+		Started: time.Now(),
+	}
+
+	a, err := remote.NewAgent(*serverPtr, tlsConfig, run, *spoolPtr)
+	if err != nil {
+		slog.Error("cannot create agent", "err", err)
+		return 1
+	}
+
+	// the agent outlives the monitors, so it can send their last measurements and the run's final metadata
+	ctx, cancel := context.WithCancel(context.Background())
+	agentCtx, cancelAgent := context.WithCancel(context.Background())
+	defer cancelAgent()
+
+	// the measurements of all types go through the same channel
+	values := make(chan measurements.Measurement)
+
+	agentDone := make(chan struct{})
+	go func() {
+		a.Start(agentCtx, values)
+		close(agentDone)
+	}()
+
+	var wg sync.WaitGroup
+
+	// the alerting rules see the measurements on their way to the agent,
+	// like the gaps, the alerts are only logged since the server doesn't accept internal types
+	source := values
+	var stopRule <-chan string
+	if len(file.Rules) > 0 {
+		source = make(chan measurements.Measurement)
+		tap := make(chan measurements.Measurement, 64)
+		go monitor.Tee(ctx, source, values, tap)
+
+		engine := alert.NewEngine(file.Rules, run.Host, nil)
+		stopRule = engine.Stop()
+
+		wg.Add(1)
+		go func() {
+			engine.Start(ctx, tap)
+			wg.Done()
+		}()
+	}
+
+	ticker := multitick.NewTicker(interval, 0)
+	for _, mType := range types {
+		mType := mType
+		wg.Add(1)
+		go func() {
+			monitor.Monitor(ctx, ticker.Subscribe(), source, nil, mType, interval)
+			wg.Done()
+		}()
+	}
+
+	slog.Info("recording", "run", run.ID, "server", *serverPtr)
+
+	var timeout <-chan time.Time
+	if *durationPtr > 0 {
+		timeout = time.After(time.Duration(*durationPtr) * time.Second)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-timeout:
+		slog.Info("timer over, quitting ...")
+		run.StopReason = "duration"
+	case <-interrupt:
+		slog.Info("interrupted, quitting ...")
+		run.StopReason = "interrupt"
+	case rule := <-stopRule:
+		slog.Info("alert stopped the recording, quitting ...", "rule", rule)
+		run.StopReason = fmt.Sprintf("rule %s fired", rule)
+	}
+
+	cancel()
+	wg.Wait()
+
+	run.Errors = collectionErrors(types)
+
+	a.End(run)
+	<-agentDone
+
+	return 0
+}
+
+// server receives the runs of agents and writes them like local recordings, one output directory per run
+// in a directory per host, or into a shared database
+//
+//	stattrack server [-listen <addr>] [-d <dir>] [-o csv|sqlite] [-db <file>] [-run-timeout <duration>] [-tls-cert <file> -tls-key <file> [-tls-ca <file>]]
+func server(args []string) int {
+
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: stattrack server [-listen <addr>] [-d <dir>] [-o csv|sqlite] [-db <file>] [-run-timeout <duration>] [-tls-cert <file> -tls-key <file> [-tls-ca <file>]]")
+		fmt.Fprintln(flags.Output(), "Without -tls-ca, anyone who can reach the listening address can send runs, which are written to the output directory or database.")
+		flags.PrintDefaults()
+	}
+
+	listenPtr := flags.String("listen", ":7070", "address to accept agents on")
+	directoryPtr := flags.String("d", ".", "output directory")
+	formatPtr := flags.String("o", "csv", "output format [csv|sqlite]")
+	databasePtr := flags.String("db", "", "shared sqlite database for the runs of all agents")
+	runTimeoutPtr := flags.Duration("run-timeout", time.Hour, "end a run once its agent hasn't sent anything for this long, e.g., because it crashed. It's resumed if the agent comes back. Not positive to keep runs forever.")
+	certPtr := flags.String("tls-cert", "", "accept agents with TLS using this certificate")
+	keyPtr := flags.String("tls-key", "", "the certificate's private key")
+	caPtr := flags.String("tls-ca", "", "only accept agents with a client certificate signed by this CA certificate, see agent -cert. Requires -tls-cert.")
+	logging := addLogFlags(flags)
+
+	flags.Parse(args)
+	if (*formatPtr != "csv" && *formatPtr != "sqlite") || (*certPtr == "") != (*keyPtr == "") || (*caPtr != "" && *certPtr == "") {
+		flags.Usage()
+		return 2
+	}
+
+	logging.setup(os.Stderr)
+
+	// runs are stored by host
+	outdir := func(run persistence.Run) string {
+		return path.Join(*directoryPtr, run.Host, "output-"+run.ID)
+	}
+
+	newBackend := func(ctx context.Context, run persistence.Run, mType measurements.MeasurementType, values <-chan measurements.Measurement) (persistence.Backend, error) {
+		switch {
+		case *databasePtr != "":
+			return persistence.NewSharedSqliteBackend(ctx, values, *databasePtr, mType, run)
+		case *formatPtr == "csv":
+			return persistence.NewCSVBackend(ctx, values, outdir(run), mType)
+		default:
+			return persistence.NewSqliteBackend(ctx, values, outdir(run), mType, "data.db")
+		}
+	}
+
+	writeRun := func(run persistence.Run) error {
+		if *databasePtr != "" {
+			return persistence.WriteSharedRun(context.Background(), *databasePtr, run)
+		}
+		return persistence.WriteRun(outdir(run), run)
+	}
+
+	readRun := func(run persistence.Run) (persistence.Run, error) {
+		if *databasePtr != "" {
+			return persistence.ReadSharedRun(context.Background(), *databasePtr, run.ID)
+		}
+		return persistence.ReadRun(outdir(run))
+	}
+
+	listener, err := net.Listen("tcp", *listenPtr)
+	if err != nil {
+		slog.Error("cannot listen", "addr", *listenPtr, "err", err)
+		return 1
+	}
+	if *certPtr != "" {
+		cert, err := tls.LoadX509KeyPair(*certPtr, *keyPtr)
+		if err != nil {
+			slog.Error("cannot load TLS certificate", "err", err)
+			return 1
+		}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+		if *caPtr != "" {
+			tlsConfig.ClientCAs, err = certPool(*caPtr)
+			if err != nil {
+				slog.Error("cannot read CA certificate", "err", err)
+				return 1
+			}
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	if *caPtr == "" {
+		slog.Warn("agents aren't authenticated, anyone who can connect can send runs, see -tls-ca", "addr", *listenPtr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		slog.Info("interrupted, quitting ...")
+		cancel()
+	}()
+
+	s := remote.NewServer(newBackend, writeRun, readRun)
+	s.SetRunTimeout(*runTimeoutPtr)
+
+	err = s.Serve(ctx, listener)
+	if err != nil {
+		slog.Error("server failed", "err", err)
+		return 1
+	}
+
+	return 0
+}
+
+// certPool reads the PEM encoded certificates in `file`
+func certPool(file string) (*x509.CertPool, error) {
+
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+
+	return pool, nil
+}
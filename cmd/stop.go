@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// how often the stop conditions are checked
+const stopCheckInterval = time.Second
+
+// stopConditions end a recording before its duration is over
+type stopConditions struct {
+	file    string   // stop once this file exists
+	pid     int      // stop once this process has exited, 0 if unset
+	maxSize byteSize // stop once the output is at least this large, 0 if unset
+	output  []string // files and directories whose sizes add up to the output size
+}
+
+func (s stopConditions) empty() bool {
+	return s.file == "" && s.pid == 0 && s.maxSize == 0
+}
+
+// watch checks the conditions periodically until one of them holds or the context is cancelled.
+// The returned channel receives the reason the recording has to stop.
+func (s stopConditions) watch(ctx context.Context) <-chan string {
+
+	stop := make(chan string, 1)
+	if s.empty() {
+		return stop
+	}
+
+	go func() {
+		ticker := time.NewTicker(stopCheckInterval)
+		defer ticker.Stop()
+		for {
+			if reason := s.check(); reason != "" {
+				stop <- reason
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// check returns why the recording has to stop or "" if it doesn't
+func (s stopConditions) check() string {
+
+	if s.file != "" {
+		if _, err := os.Stat(s.file); err == nil {
+			return fmt.Sprintf("file %s exists", s.file)
+		}
+	}
+
+	// signal 0 only checks whether the process exists, EPERM means it does but belongs to someone else
+	if s.pid != 0 {
+		if err := unix.Kill(s.pid, 0); errors.Is(err, unix.ESRCH) {
+			return fmt.Sprintf("process %d exited", s.pid)
+		}
+	}
+
+	if s.maxSize > 0 {
+		if size := outputSize(s.output); size >= int64(s.maxSize) {
+			return fmt.Sprintf("output size %s reached", byteSize(size))
+		}
+	}
+
+	return ""
+}
+
+// outputSize adds up the sizes of the files in `paths`, including the files in directories
+func outputSize(paths []string) int64 {
+
+	var size int64
+
+	for _, p := range paths {
+		filepath.WalkDir(p, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // e.g., the directory isn't created yet or a file was just removed
+			}
+			if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+
+	return size
+}
+
+// byteSize is a number of bytes that can be used as a command line flag, e.g., 500M or 2G
+type byteSize int64
+
+var byteSuffixes = []string{"B", "K", "M", "G", "T"}
+
+func (b byteSize) String() string {
+	size := float64(b)
+	i := 0
+	for size >= 1024 && i < len(byteSuffixes)-1 {
+		size /= 1024
+		i++
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(size, 'f', 1, 64), ".0") + byteSuffixes[i]
+}
+
+func (b *byteSize) Set(value string) error {
+
+	value = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+
+	factor := int64(1)
+	for i := len(byteSuffixes) - 1; i > 0; i-- {
+		if strings.HasSuffix(value, byteSuffixes[i]) {
+			value = strings.TrimSuffix(value, byteSuffixes[i])
+			factor = 1 << (10 * i)
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return errors.New("expected a size like 500M or 2G")
+	}
+	*b = byteSize(n * float64(factor))
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// startConditions delay persisting measurements until one of them holds
+type startConditions struct {
+	at         string        // a point in time, see parseStartTime
+	signal     bool          // start on SIGUSR1
+	command    bool          // start on the control socket's start command
+	when       stringList    // metric conditions, e.g., cpu.userp > 50
+	pretrigger time.Duration // how much of the time before the trigger is kept
+}
+
+// armed tells whether the recording waits for a trigger
+func (s startConditions) armed() bool {
+	return s.at != "" || s.signal || s.command || len(s.when) > 0
+}
+
+// parseStartTime parses an RFC 3339 timestamp like 2024-01-31T22:00:00+01:00
+// or a time of day like 22:00 or 22:00:30, meaning the next time the clock shows it
+func parseStartTime(value string, now time.Time) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{time.TimeOnly, "15:04"} {
+		t, err := time.ParseInLocation(layout, value, now.Location())
+		if err != nil {
+			continue
+		}
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid start time %q, expected an RFC 3339 timestamp or a time of day like 22:00", value)
+}
+
+// control socket commands
+const (
+	commandStart = "start"
+	commandStop  = "stop"
+)
+
+// listenControl accepts commands on a unix socket, one per line, e.g., `echo start | nc -U stattrack.sock`.
+// Valid commands are sent to the returned channel and acknowledged with "ok".
+func listenControl(ctx context.Context, file string) (<-chan string, error) {
+
+	// a socket left over by a previous run would make listening fail
+	if info, err := os.Stat(file); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(file)
+	}
+
+	listener, err := net.Listen("unix", file)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make(chan string)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close() // also removes the socket file
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				slog.Error("control socket", "err", err)
+				return
+			}
+			go handleControl(ctx, conn, commands)
+		}
+	}()
+
+	slog.Info("listening for commands", "socket", file)
+
+	return commands, nil
+}
+
+func handleControl(ctx context.Context, conn net.Conn, commands chan<- string) {
+
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+
+		command := strings.TrimSpace(scanner.Text())
+		if command != commandStart && command != commandStop {
+			fmt.Fprintf(conn, "unknown command %q, expected %s or %s\n", command, commandStart, commandStop)
+			continue
+		}
+
+		slog.Debug("control command", "command", command)
+		select {
+		case commands <- command:
+			fmt.Fprintln(conn, "ok")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// how long a command or webhook may take, they aren't cancelled with the recording
+// so they can still report why it stopped
+const actionTimeout = 10 * time.Second
+
+// runCommand runs a rule's command with sh -c. The event is passed in environment variables.
+func runCommand(command string, alert measurements.Alert) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"STATTRACK_RULE="+alert.Rule,
+		"STATTRACK_KEY="+alert.Key,
+		"STATTRACK_STATE="+alert.State,
+		fmt.Sprintf("STATTRACK_VALUE=%g", alert.Value),
+		fmt.Sprintf("STATTRACK_SINCE=%d", alert.Since),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Error("alert command failed", "rule", alert.Rule, "command", command, "output", string(output), "err", err)
+		return
+	}
+	slog.Debug("alert command done", "rule", alert.Rule, "output", string(output))
+}
+
+// webhookPayload is the JSON body POSTed to a rule's webhook
+type webhookPayload struct {
+	Rule      string  `json:"rule"`
+	Key       string  `json:"key,omitempty"`
+	State     string  `json:"state"`
+	Value     float64 `json:"value"`
+	Since     int64   `json:"since"`
+	Timestamp int64   `json:"timestamp"`
+	Host      string  `json:"host"`
+}
+
+// postWebhook POSTs an event as JSON to a rule's webhook
+func postWebhook(url, host string, alert measurements.Alert) {
+
+	body, err := json.Marshal(webhookPayload{
+		Rule:      alert.Rule,
+		Key:       alert.Key,
+		State:     alert.State,
+		Value:     alert.Value,
+		Since:     alert.Since,
+		Timestamp: alert.Timestamp,
+		Host:      host,
+	})
+	if err != nil {
+		slog.Error("could not encode webhook payload", "rule", alert.Rule, "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("invalid webhook", "rule", alert.Rule, "url", url, "err", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		slog.Error("webhook failed", "rule", alert.Rule, "url", url, "err", err)
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		slog.Error("webhook failed", "rule", alert.Rule, "url", url, "status", response.Status)
+	}
+}
@@ -0,0 +1,263 @@
+package alert
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// state of a rule for one key
+type state struct {
+	since  int64 // unix timestamp of when the condition became true, 0 if it isn't
+	firing bool
+	value  float64 // the last value, reported when a firing alert is resolved by a reload
+}
+
+// event is an alert caused by `rule`
+type event struct {
+	rule  Rule
+	alert measurements.Alert
+}
+
+// Engine evaluates alerting rules against the measurements it receives and sends the resulting events to a backend
+type Engine struct {
+	rules  []Rule
+	host   string                          // sent with webhooks
+	events chan<- measurements.Measurement // may be nil, the events are logged anyway
+	states map[int]map[string]*state       // by rule index and key
+	stop   chan string
+	reload chan []Rule
+	done   chan struct{}
+
+	actions sync.WaitGroup // running commands and webhooks
+}
+
+func NewEngine(rules []Rule, host string, events chan<- measurements.Measurement) *Engine {
+	return &Engine{
+		rules:  rules,
+		host:   host,
+		events: events,
+		states: make(map[int]map[string]*state),
+		stop:   make(chan string, 1),
+		reload: make(chan []Rule),
+		done:   make(chan struct{}),
+	}
+}
+
+// Reload replaces the rules of the running engine. Rules with the same name and condition as before keep their state,
+// so their alerts keep firing (or waiting for `for`) across reloads. Alerts firing for rules that were removed or
+// changed are resolved.
+func (e *Engine) Reload(rules []Rule) {
+	select {
+	case e.reload <- rules:
+	case <-e.done:
+	}
+}
+
+// Stop receives the name of the first rule with `stop` set that fires
+func (e *Engine) Stop() <-chan string {
+	return e.stop
+}
+
+// Start evaluates the rules on every value until the context is cancelled
+func (e *Engine) Start(ctx context.Context, values <-chan measurements.Measurement) error {
+
+	defer close(e.done)
+
+	slog.Info("alerting starting", "rules", len(e.rules))
+
+	for {
+		select {
+		case value := <-values:
+			{
+				for _, ev := range e.evaluate(value) {
+					e.handle(ctx, ev)
+				}
+			}
+		case rules := <-e.reload:
+			{
+				for _, ev := range e.replace(rules) {
+					e.handle(ctx, ev)
+				}
+				slog.Info("alerting rules reloaded", "rules", len(e.rules))
+			}
+		case <-ctx.Done():
+			{
+				// actions of the last events still get to finish, e.g., a notification about why recording stopped
+				e.actions.Wait()
+				slog.Info("alerting done")
+				return nil
+			}
+		}
+	}
+}
+
+// evaluate updates the rules' states with a measurement and returns the events it caused
+func (e *Engine) evaluate(value measurements.Measurement) []event {
+
+	mType, err := measurements.TypeOf(value)
+	if err != nil {
+		return nil
+	}
+
+	var record []string
+	var events []event
+
+	for i, rule := range e.rules {
+
+		if rule.mType != mType {
+			continue
+		}
+
+		if record == nil {
+			record, err = value.Record()
+			if err != nil {
+				return nil
+			}
+		}
+
+		// a missing value can't hold or resolve a condition, e.g., NaN when there is no previous measurement
+		number, err := strconv.ParseFloat(record[rule.column], 64)
+		if err != nil || math.IsNaN(number) {
+			continue
+		}
+		timestamp, _ := strconv.ParseInt(record[0], 10, 64)
+
+		keys := make([]string, len(rule.keys))
+		for k, column := range rule.keys {
+			keys[k] = strings.Trim(record[column], "'")
+		}
+		key := strings.Join(keys, ",")
+
+		if e.states[i] == nil {
+			e.states[i] = make(map[string]*state)
+		}
+		s, ok := e.states[i][key]
+		if !ok {
+			s = &state{}
+			e.states[i][key] = s
+		}
+
+		s.value = number
+
+		alert := measurements.Alert{
+			Timestamp: timestamp,
+			Rule:      rule.Name,
+			Key:       key,
+			Value:     number,
+		}
+
+		switch {
+		case rule.holds(number):
+			if s.since == 0 {
+				s.since = timestamp
+			}
+			if !s.firing && time.Duration(timestamp-s.since)*time.Second >= rule.duration {
+				s.firing = true
+				alert.State, alert.Since = measurements.Firing, s.since
+				events = append(events, event{rule, alert})
+			}
+		case s.firing:
+			alert.State, alert.Since = measurements.Resolved, s.since
+			events = append(events, event{rule, alert})
+			s.firing, s.since = false, 0
+		default:
+			s.since = 0
+		}
+	}
+
+	return events
+}
+
+// replace swaps the rules, carries over the states of the rules that are kept
+// and returns the resolving events of the alerts firing for the others
+func (e *Engine) replace(rules []Rule) []event {
+
+	var events []event
+
+	states := make(map[int]map[string]*state)
+
+	for i, old := range e.rules {
+
+		kept := slices.IndexFunc(rules, func(r Rule) bool {
+			return r.Name == old.Name && r.When == old.When
+		})
+		if kept >= 0 {
+			if _, ok := states[kept]; !ok {
+				states[kept] = e.states[i]
+				continue
+			}
+		}
+
+		for key, s := range e.states[i] {
+			if s.firing {
+				events = append(events, event{old, measurements.Alert{
+					Timestamp: time.Now().Unix(),
+					Rule:      old.Name,
+					Key:       key,
+					State:     measurements.Resolved,
+					Value:     s.value,
+					Since:     s.since,
+				}})
+			}
+		}
+	}
+
+	slices.SortFunc(events, func(a, b event) int {
+		return strings.Compare(a.alert.Rule+"\x00"+a.alert.Key, b.alert.Rule+"\x00"+b.alert.Key)
+	})
+
+	e.rules, e.states = rules, states
+
+	return events
+}
+
+// handle records an event and runs the rule's actions
+func (e *Engine) handle(ctx context.Context, ev event) {
+
+	rule, alert := ev.rule, ev.alert
+
+	logger := slog.With("rule", alert.Rule, "key", alert.Key, "value", alert.Value)
+	if alert.State == measurements.Firing {
+		logger.Warn("alert firing", "condition", rule.When)
+	} else {
+		logger.Info("alert resolved")
+	}
+
+	if e.events != nil {
+		select {
+		case e.events <- alert:
+		case <-ctx.Done():
+		}
+	}
+
+	// the actions mustn't hold up the evaluation
+	if rule.Command != "" {
+		e.actions.Add(1)
+		go func() {
+			defer e.actions.Done()
+			runCommand(rule.Command, alert)
+		}()
+	}
+	if rule.Webhook != "" {
+		e.actions.Add(1)
+		go func() {
+			defer e.actions.Done()
+			postWebhook(rule.Webhook, e.host, alert)
+		}()
+	}
+
+	if rule.Stop && alert.State == measurements.Firing {
+		select {
+		case e.stop <- rule.Name:
+		default:
+		}
+	}
+}
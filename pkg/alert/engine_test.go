@@ -0,0 +1,104 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// cpu returns a CPU measurement with `userp` at `timestamp`
+func cpu(timestamp int64, userp string) measurements.Row {
+	return measurements.Row{
+		Type:   measurements.CPU,
+		Values: []string{fmt.Sprint(timestamp), "0", "0", "0", "0", "0", userp, "0", "0", "0"},
+	}
+}
+
+func mustParse(t *testing.T, name, when string) Rule {
+	t.Helper()
+	rule, err := ParseRule(name, when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestEvaluateNaN(t *testing.T) {
+
+	e := NewEngine([]Rule{mustParse(t, "not idle", "cpu.userp != 0")}, "host", nil)
+
+	// there is no percentage without a previous measurement
+	if events := e.evaluate(cpu(1, "NaN")); len(events) != 0 {
+		t.Errorf("expected NaN to be skipped, got %+v", events)
+	}
+
+	events := e.evaluate(cpu(2, "5"))
+	if len(events) != 1 || events[0].alert.State != measurements.Firing {
+		t.Fatalf("expected the rule to fire, got %+v", events)
+	}
+
+	// nor does NaN resolve a firing alert
+	if events := e.evaluate(cpu(3, "NaN")); len(events) != 0 {
+		t.Errorf("expected NaN to be skipped, got %+v", events)
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	kept := mustParse(t, "busy", "cpu.userp > 50")
+	changed := mustParse(t, "very busy", "cpu.userp > 80")
+	pending := mustParse(t, "long busy", "cpu.userp > 50 for 10s")
+
+	events := make(chan measurements.Measurement, 16)
+	e := NewEngine([]Rule{kept, changed, pending}, "host", events)
+
+	values := make(chan measurements.Measurement)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Start(ctx, values)
+		close(done)
+	}()
+
+	values <- cpu(100, "90")
+	for _, rule := range []string{"busy", "very busy"} {
+		a := (<-events).(measurements.Alert)
+		if a.Rule != rule || a.State != measurements.Firing {
+			t.Fatalf("expected %s to fire, got %+v", rule, a)
+		}
+	}
+
+	// the changed rule's alert is resolved, the others keep their state
+	e.Reload([]Rule{kept, mustParse(t, "very busy", "cpu.userp > 95"), pending})
+
+	a := (<-events).(measurements.Alert)
+	if a.Rule != "very busy" || a.State != measurements.Resolved || a.Value != 90 || a.Since != 100 {
+		t.Errorf("expected the changed rule to resolve, got %+v", a)
+	}
+
+	// the pending rule still counts from before the reload, the kept rule doesn't fire again
+	values <- cpu(110, "90")
+	a = (<-events).(measurements.Alert)
+	if a.Rule != "long busy" || a.State != measurements.Firing || a.Since != 100 {
+		t.Errorf("expected the pending rule to fire after 10s, got %+v", a)
+	}
+
+	// removing a rule resolves its alert
+	e.Reload([]Rule{pending})
+	a = (<-events).(measurements.Alert)
+	if a.Rule != "busy" || a.State != measurements.Resolved {
+		t.Errorf("expected the removed rule to resolve, got %+v", a)
+	}
+
+	cancel()
+	<-done
+
+	select {
+	case a := <-events:
+		t.Errorf("unexpected event %+v", a)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
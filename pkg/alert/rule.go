@@ -0,0 +1,167 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// Rule fires when its condition has been true for at least `For`, e.g., `cpu.userp > 90 for 30s`.
+// The condition compares a column of a measurement type, named like the output files, with a threshold.
+type Rule struct {
+	Name    string `json:"name"`
+	When    string `json:"when"`              // the condition, e.g., cpu.userp > 90 for 30s
+	Command string `json:"command,omitempty"` // run with sh -c when the rule fires or resolves
+	Webhook string `json:"webhook,omitempty"` // URL the events are POSTed to as JSON
+	Stop    bool   `json:"stop,omitempty"`    // stop recording when the rule fires
+
+	// parsed from When
+	mType     measurements.MeasurementType
+	column    int
+	keys      []int // text columns, a rule is evaluated separately for each of their values
+	operator  string
+	threshold float64
+	duration  time.Duration
+}
+
+// Config is the content of an alerting config file
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// <type>.<column> <operator> <threshold> [for <duration>]
+var conditionPattern = regexp.MustCompile(`^\s*(\w+)\.(\w+)\s*(>=|<=|==|!=|>|<)\s*(\S+?)\s*(?:\s+for\s+(\S+))?\s*$`)
+
+// ParseRule parses a condition like `memory.freep < 5` or `cpu.userp > 90 for 30s` into a rule without actions
+func ParseRule(name, when string) (Rule, error) {
+
+	rule := Rule{Name: name, When: when}
+	return rule, rule.parse()
+}
+
+func (r *Rule) parse() error {
+
+	match := conditionPattern.FindStringSubmatch(r.When)
+	if match == nil {
+		return fmt.Errorf("rule %s: invalid condition %q, expected <type>.<column> <operator> <threshold> [for <duration>]", r.Name, r.When)
+	}
+	typeName, columnName, operator, threshold, duration := match[1], match[2], match[3], match[4], match[5]
+
+	var err error
+
+	r.mType, err = typeByName(typeName)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+
+	names, err := measurements.GetColumnNames(r.mType)
+	if err != nil {
+		return err
+	}
+	types, err := measurements.GetColumnTypes(r.mType)
+	if err != nil {
+		return err
+	}
+
+	r.column = slices.Index(names, columnName)
+	if r.column < 0 {
+		return fmt.Errorf("rule %s: %s has no column %s, expected one of %s", r.Name, typeName, columnName, strings.Join(names, ", "))
+	}
+	if strings.HasSuffix(types[r.column], "TEXT") {
+		return fmt.Errorf("rule %s: column %s.%s is not a number", r.Name, typeName, columnName)
+	}
+
+	r.keys = nil
+	for i, columnType := range types {
+		if strings.HasSuffix(columnType, "TEXT") {
+			r.keys = append(r.keys, i)
+		}
+	}
+
+	r.operator = operator
+
+	r.threshold, err = strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return fmt.Errorf("rule %s: invalid threshold %q", r.Name, threshold)
+	}
+
+	r.duration = 0
+	if duration != "" {
+		r.duration, err = time.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid duration %q", r.Name, duration)
+		}
+	}
+
+	return nil
+}
+
+// Type returns the measurement type the rule is evaluated on
+func (r Rule) Type() measurements.MeasurementType {
+	return r.mType
+}
+
+// holds reports whether the rule's condition is true for a value
+func (r Rule) holds(value float64) bool {
+	switch r.operator {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	}
+	return false
+}
+
+// typeByName finds a measurement type by its file name, e.g., cpu or memory
+func typeByName(name string) (measurements.MeasurementType, error) {
+	for _, mType := range measurements.AllTypes {
+		if fileName, err := measurements.GetFileName(mType); err == nil && fileName == name {
+			return mType, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", measurements.ErrUnknownType, name)
+}
+
+// LoadRules reads the rules from a JSON config file like
+//
+//	{"rules": [{"name": "thrashing", "when": "memory_extended.majorFaults > 1000 for 1m", "stop": true}]}
+func LoadRules(file string) ([]Rule, error) {
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for i := range config.Rules {
+		if config.Rules[i].Name == "" {
+			config.Rules[i].Name = config.Rules[i].When
+		}
+		err = config.Rules[i].parse()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return config.Rules, nil
+}
@@ -0,0 +1,219 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/sys/unix"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// number of samples shown in the sparklines, one minute at one sample per second
+const historyLength = 60
+
+// Dashboard renders the latest measurements as a full-screen view in the terminal
+type Dashboard struct {
+	values <-chan measurements.Measurement
+	out    *os.File
+	title  string
+
+	started time.Time
+	cpu     *measurements.CPUMeasurement
+	mem     *measurements.MemoryMeasurement
+	net     map[string]measurements.NetworkMeasurement
+
+	cpuHistory []float64            // userp + systemp
+	memHistory []float64            // used / total in percent
+	netHistory map[string][]float64 // rx + tx bytes
+}
+
+func NewDashboard(values <-chan measurements.Measurement, out *os.File, title string) *Dashboard {
+	return &Dashboard{
+		values:     values,
+		out:        out,
+		title:      title,
+		started:    time.Now(),
+		net:        make(map[string]measurements.NetworkMeasurement),
+		netHistory: make(map[string][]float64),
+	}
+}
+
+// Start renders the dashboard once per second until the context is cancelled
+func (d *Dashboard) Start(ctx context.Context) error {
+
+	// alternate screen buffer, hidden cursor
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	d.render()
+
+	for {
+		select {
+		case value := <-d.values:
+			{
+				d.update(value)
+			}
+		case <-ticker.C:
+			{
+				d.render()
+			}
+		case <-ctx.Done():
+			{
+				return nil
+			}
+		}
+	}
+}
+
+func (d *Dashboard) update(value measurements.Measurement) {
+
+	push := func(history []float64, value float64) []float64 {
+		history = append(history, value)
+		if len(history) > historyLength {
+			history = history[len(history)-historyLength:]
+		}
+		return history
+	}
+
+	switch m := value.(type) {
+	case measurements.CPUMeasurement:
+		// the first CPU measurement has no percentages yet
+		if math.IsNaN(m.Userp) {
+			return
+		}
+		d.cpu = &m
+		d.cpuHistory = push(d.cpuHistory, m.Userp+m.Systp)
+	case measurements.MemoryMeasurement:
+		d.mem = &m
+		d.memHistory = push(d.memHistory, percent(m.Used, m.Total))
+	case measurements.NetworkMeasurement:
+		d.net[m.Interface] = m
+		d.netHistory[m.Interface] = push(d.netHistory[m.Interface], m.RxBytesPerSec+m.TxBytesPerSec)
+	}
+}
+
+func (d *Dashboard) render() {
+
+	width := terminalWidth(d.out)
+	barWidth := max(width-40, 10)
+
+	var b strings.Builder
+
+	// clear screen, cursor to the top left
+	b.WriteString("\x1b[H\x1b[2J")
+
+	fmt.Fprintf(&b, "%s  %s  running for %s\n\n",
+		color.New(color.Bold).Sprint("stattrack"),
+		d.title,
+		time.Since(d.started).Truncate(time.Second),
+	)
+
+	if d.cpu != nil {
+		fmt.Fprintln(&b, color.New(color.Bold).Sprint("CPU"))
+		fmt.Fprintf(&b, "  user   %6.2f%% %s\n", d.cpu.Userp, bar(d.cpu.Userp, barWidth))
+		fmt.Fprintf(&b, "  system %6.2f%% %s\n", d.cpu.Systp, bar(d.cpu.Systp, barWidth))
+		fmt.Fprintf(&b, "  idle   %6.2f%% %s\n", d.cpu.Idlep, bar(d.cpu.Idlep, barWidth))
+		fmt.Fprintf(&b, "  last minute    %s\n\n", sparkline(d.cpuHistory, 100))
+	}
+
+	if d.mem != nil {
+		fmt.Fprintln(&b, color.New(color.Bold).Sprint("Memory"))
+		fmt.Fprintf(&b, "  used   %6.2f%% %s %s / %s\n",
+			percent(d.mem.Used, d.mem.Total), bar(percent(d.mem.Used, d.mem.Total), barWidth),
+			bytes(float64(d.mem.Used)), bytes(float64(d.mem.Total)),
+		)
+		fmt.Fprintf(&b, "  swap   %6.2f%% %s %s / %s\n",
+			percent(d.mem.SwapUsed, d.mem.SwapTotal), bar(percent(d.mem.SwapUsed, d.mem.SwapTotal), barWidth),
+			bytes(float64(d.mem.SwapUsed)), bytes(float64(d.mem.SwapTotal)),
+		)
+		fmt.Fprintf(&b, "  last minute    %s\n\n", sparkline(d.memHistory, 100))
+	}
+
+	if len(d.net) > 0 {
+		fmt.Fprintln(&b, color.New(color.Bold).Sprint("Network"))
+
+		names := make([]string, 0, len(d.net))
+		for name := range d.net {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			m := d.net[name]
+			fmt.Fprintf(&b, "  %-12.12s rx %10s/s  tx %10s/s  %s\n",
+				name, bytes(m.RxBytesPerSec), bytes(m.TxBytesPerSec),
+				sparkline(d.netHistory[name], 0),
+			)
+		}
+	}
+
+	io.WriteString(d.out, b.String())
+}
+
+// terminalWidth returns the width of the terminal or 80 if it isn't one
+func terminalWidth(f *os.File) int {
+	size, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 {
+		return 80
+	}
+	return int(size.Col)
+}
+
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// bar draws a horizontal bar for a percentage
+func bar(pct float64, width int) string {
+	filled := int(pct / 100 * float64(width))
+	filled = min(max(filled, 0), width)
+	return "[" + color.GreenString(strings.Repeat("|", filled)) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// sparkline draws the history with block characters, scaled to `top` or to the history's maximum if `top` is zero
+func sparkline(history []float64, top float64) string {
+
+	blocks := []rune("▁▂▃▄▅▆▇█")
+
+	if top == 0 {
+		for _, value := range history {
+			top = max(top, value)
+		}
+	}
+
+	var b strings.Builder
+	for _, value := range history {
+		i := 0
+		if top > 0 {
+			i = int(value / top * float64(len(blocks)-1))
+		}
+		b.WriteRune(blocks[min(max(i, 0), len(blocks)-1)])
+	}
+
+	return b.String()
+}
+
+// bytes formats a number of bytes with a binary unit
+func bytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
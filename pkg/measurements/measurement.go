@@ -3,12 +3,11 @@ package measurements
 import (
 	"errors"
 	"fmt"
-	"log"
 	"math"
+	"slices"
 	"strconv"
-
-	"github.com/fatih/color"
-	netstat "github.com/mackerelio/go-osstat/network"
+	"strings"
+	"time"
 )
 
 type MeasurementType uint
@@ -17,10 +16,35 @@ const (
 	CPU MeasurementType = iota // TODO does adding the type here break stuff?
 	MEM
 	NET
+	PROTO
+	LOAD
+	PSI
+	FS
+	CGROUP
+	LIMITS
+	MEMX
+	SENSORS
 )
 
 type MeasurementTypes []MeasurementType
 
+// AllTypes lists every measurement type that can be recorded
+var AllTypes = MeasurementTypes{CPU, MEM, NET, PROTO, LOAD, PSI, FS, CGROUP, LIMITS, MEMX, SENSORS}
+
+// internal types can't be selected with -m, they are recorded alongside the selected types
+const (
+	GAP MeasurementType = 100 + iota
+	ALERT
+)
+
+// InternalTypes lists every internal measurement type
+var InternalTypes = MeasurementTypes{GAP, ALERT}
+
+// StoredTypes lists every measurement type that can be found in a recording
+func StoredTypes() MeasurementTypes {
+	return append(append(MeasurementTypes{}, AllTypes...), InternalTypes...)
+}
+
 func (m *MeasurementTypes) String() string {
 	var res string
 	for _, n := range *m {
@@ -32,13 +56,28 @@ func (m *MeasurementTypes) String() string {
 func (m *MeasurementTypes) Set(value string) error {
 	n, err := strconv.Atoi(value)
 	if err != nil {
-		log.Println("error while trying append MeasurementType value")
 		return err
 	}
+	if n < 0 || !MeasurementType(n).Valid() {
+		return ErrUnknownType
+	}
 	*m = append(*m, MeasurementType(n))
 	return nil
 }
 
+// ErrUnknownType is returned for measurement types that don't exist
+var ErrUnknownType = errors.New("unknown measurement type")
+
+// Valid reports whether the measurement type exists
+func (m MeasurementType) Valid() bool {
+	for _, t := range AllTypes {
+		if t == m {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO delete if not used anymore
 //
 //	(but double check first)
@@ -50,8 +89,28 @@ func (m *MeasurementType) String() string {
 		return "memory"
 	case NET:
 		return "network"
+	case PROTO:
+		return "protocols"
+	case LOAD:
+		return "load"
+	case PSI:
+		return "pressure"
+	case FS:
+		return "filesystems"
+	case CGROUP:
+		return "cgroups"
+	case LIMITS:
+		return "limits"
+	case MEMX:
+		return "extended memory"
+	case SENSORS:
+		return "sensors"
+	case GAP:
+		return "gaps"
+	case ALERT:
+		return "alerts"
 	default:
-		panic("no such measurement type")
+		return fmt.Sprintf("unknown(%d)", uint(*m))
 	}
 }
 
@@ -59,7 +118,49 @@ type Measurement interface {
 	Record() ([]string, error)
 }
 
-func GetColumnNames(mType MeasurementType) []string {
+// Reference is implemented by measurements that can lack the values computed from a previous measurement,
+// e.g., the first measurement of a network interface. Those are only kept to compute the next measurement
+// and aren't recorded.
+type Reference interface {
+	IsReference() bool
+}
+
+// TypeOf returns the MeasurementType belonging to a measurement's concrete type
+func TypeOf(value Measurement) (MeasurementType, error) {
+	switch value.(type) {
+	case CPUMeasurement:
+		return CPU, nil
+	case MemoryMeasurement:
+		return MEM, nil
+	case NetworkMeasurement:
+		return NET, nil
+	case ProtocolMeasurement:
+		return PROTO, nil
+	case LoadMeasurement:
+		return LOAD, nil
+	case PressureMeasurement:
+		return PSI, nil
+	case FilesystemMeasurement:
+		return FS, nil
+	case CgroupMeasurement:
+		return CGROUP, nil
+	case LimitsMeasurement:
+		return LIMITS, nil
+	case ExtendedMemoryMeasurement:
+		return MEMX, nil
+	case SensorMeasurement:
+		return SENSORS, nil
+	case Gap:
+		return GAP, nil
+	case Alert:
+		return ALERT, nil
+	case Row:
+		return value.(Row).Type, nil
+	}
+	return 0, fmt.Errorf("%w: %T", ErrUnknownType, value)
+}
+
+func GetColumnNames(mType MeasurementType) ([]string, error) {
 	switch mType {
 	case CPU:
 		return []string{
@@ -72,7 +173,8 @@ func GetColumnNames(mType MeasurementType) []string {
 			"userp",
 			"systemp",
 			"idlep",
-		}
+			"latency",
+		}, nil
 	case MEM:
 		return []string{
 			"timestamp",
@@ -86,56 +188,460 @@ func GetColumnNames(mType MeasurementType) []string {
 			"swapUsed",
 			"used",
 			"freep",
-		}
+			"latency",
+		}, nil
 	case NET:
 		return []string{
 			"timestamp",
 			"name",
 			"RxBytes",
 			"TxBytes",
+			"RxPackets",
+			"TxPackets",
+			"RxErrors",
+			"TxErrors",
+			"RxDropped",
+			"TxDropped",
+			"RxFifo",
+			"TxFifo",
+			"multicast",
+			"RxBytesPerSec",
+			"TxBytesPerSec",
+			"RxPacketsPerSec",
+			"TxPacketsPerSec",
+			"latency",
+		}, nil
+	case PROTO:
+		return []string{
+			"timestamp",
+			"activeOpens",
+			"passiveOpens",
+			"attemptFails",
+			"estabResets",
+			"outRsts",
+			"retransSegs",
+			"listenDrops",
+			"udpInErrors",
+			"udpRcvbufErrors",
+			"established",
+			"timeWait",
+			"latency",
+		}, nil
+	case LOAD:
+		return []string{
+			"timestamp",
+			"load1",
+			"load5",
+			"load15",
+			"running",
+			"blocked",
+			"ctxtPerSec",
+			"intrPerSec",
+			"forksPerSec",
+			"latency",
+		}, nil
+	case PSI:
+		return []string{
+			"timestamp",
+			"resource",
+			"someAvg10",
+			"someAvg60",
+			"someStall",
+			"fullAvg10",
+			"fullAvg60",
+			"fullStall",
+			"latency",
+		}, nil
+	case FS:
+		return []string{
+			"timestamp",
+			"mountpoint",
+			"type",
+			"device",
+			"total",
+			"used",
+			"available",
+			"usedp",
+			"inodes",
+			"inodesUsed",
+			"inodesFree",
+			"latency",
+		}, nil
+	case CGROUP:
+		return []string{
+			"timestamp",
+			"cgroup",
+			"usageUsec",
+			"userUsec",
+			"systemUsec",
+			"cpup",
+			"memoryCurrent",
+			"anon",
+			"file",
+			"readBytes",
+			"writeBytes",
+			"readOps",
+			"writeOps",
+			"pids",
+			"latency",
+		}, nil
+	case LIMITS:
+		return []string{
+			"timestamp",
+			"cgroup",
+			"memoryCurrent",
+			"memoryLimit",
+			"memoryp",
+			"cpuUsage",
+			"cpuLimit",
+			"cpup",
+			"periods",
+			"throttled",
+			"throttledUsec",
+			"latency",
+		}, nil
+	case MEMX:
+		return []string{
+			"timestamp",
+			"available",
+			"buffers",
+			"cached",
+			"dirty",
+			"writeback",
+			"slab",
+			"sReclaimable",
+			"shmem",
+			"anonHugePages",
+			"hugePagesTotal",
+			"hugePagesFree",
+			"hugePageSize",
+			"minorFaults",
+			"majorFaults",
+			"pgscan",
+			"pgsteal",
+			"swapInPerSec",
+			"swapOutPerSec",
+			"latency",
+		}, nil
+	case SENSORS:
+		return []string{
+			"timestamp",
+			"sensor",
+			"kind",
+			"value",
+			"latency",
+		}, nil
+	case GAP:
+		return []string{
+			"timestamp",
+			"type",
+			"startMs",
+			"endMs",
+			"missed",
+			"reason",
+		}, nil
+	case ALERT:
+		return []string{
+			"timestamp",
+			"rule",
+			"key",
+			"state",
+			"value",
+			"since",
+		}, nil
+	}
+	return nil, ErrUnknownType
+}
+
+// GetColumnTypes returns the sqlite column types matching GetColumnNames
+func GetColumnTypes(mType MeasurementType) ([]string, error) {
+	switch mType {
+	case CPU:
+		return []string{
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case MEM:
+		return []string{
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case NET:
+		return []string{
+			"INTEGER",
+			"TINYTEXT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case PROTO:
+		return []string{
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+		}, nil
+	case LOAD:
+		return []string{
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case PSI:
+		return []string{
+			"INTEGER",
+			"TINYTEXT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+			"INTEGER",
+		}, nil
+	case FS:
+		return []string{
+			"INTEGER",
+			"TEXT",
+			"TINYTEXT",
+			"TEXT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+		}, nil
+	case CGROUP:
+		return []string{
+			"INTEGER",
+			"TEXT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+		}, nil
+	case LIMITS:
+		return []string{
+			"INTEGER",
+			"TEXT",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+		}, nil
+	case MEMX:
+		return []string{
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"FLOAT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case SENSORS:
+		return []string{
+			"INTEGER",
+			"TEXT",
+			"TINYTEXT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	case GAP:
+		return []string{
+			"INTEGER",
+			"TINYTEXT",
+			"INTEGER",
+			"INTEGER",
+			"INTEGER",
+			"TINYTEXT",
+		}, nil
+	case ALERT:
+		return []string{
+			"INTEGER",
+			"TEXT",
+			"TEXT",
+			"TINYTEXT",
+			"FLOAT",
+			"INTEGER",
+		}, nil
+	}
+	return nil, ErrUnknownType
+}
+
+// Aggregation is how the values of a column are combined when samples are aggregated into coarser intervals
+type Aggregation int
+
+const (
+	Gauge   Aggregation = iota // a current value, e.g., the used memory, is averaged
+	Delta                      // an increase since the previous measurement, e.g., the received bytes, is summed
+	Counter                    // a value that only grows, e.g., the CPU's raw time, keeps its last value
+)
+
+// columns that aren't gauges, by measurement type
+var (
+	deltaColumns = map[MeasurementType][]string{
+		NET:    {"RxBytes", "TxBytes", "RxPackets", "TxPackets", "RxErrors", "TxErrors", "RxDropped", "TxDropped", "RxFifo", "TxFifo", "multicast"},
+		PROTO:  {"activeOpens", "passiveOpens", "attemptFails", "estabResets", "outRsts", "retransSegs", "listenDrops", "udpInErrors", "udpRcvbufErrors"},
+		PSI:    {"someStall", "fullStall"},
+		CGROUP: {"usageUsec", "userUsec", "systemUsec", "readBytes", "writeBytes", "readOps", "writeOps"},
+		LIMITS: {"periods", "throttled", "throttledUsec"},
+		MEMX:   {"minorFaults", "majorFaults", "pgscan", "pgsteal"},
+		GAP:    {"missed"},
+	}
+	counterColumns = map[MeasurementType][]string{
+		CPU: {"user", "system", "idle", "nice", "total"},
+	}
+)
+
+// GetColumnAggregations returns how the columns of GetColumnNames are aggregated.
+// The timestamp and text columns aren't aggregated, they're reported as gauges.
+func GetColumnAggregations(mType MeasurementType) ([]Aggregation, error) {
+
+	names, err := GetColumnNames(mType)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregations := make([]Aggregation, len(names))
+	for i, name := range names {
+		switch {
+		case slices.Contains(deltaColumns[mType], name):
+			aggregations[i] = Delta
+		case slices.Contains(counterColumns[mType], name):
+			aggregations[i] = Counter
 		}
-	default:
-		log.Panicln("unknown measurement type")
 	}
-	return nil
+
+	return aggregations, nil
 }
 
-func GetFileName(mType MeasurementType) string {
+func GetFileName(mType MeasurementType) (string, error) {
 	switch mType {
 	case CPU:
-		return "cpu"
+		return "cpu", nil
 	case MEM:
-		return "memory"
+		return "memory", nil
 	case NET:
-		return "network"
-	default:
-		log.Panicln("unknown measurement type")
+		return "network", nil
+	case PROTO:
+		return "protocols", nil
+	case LOAD:
+		return "load", nil
+	case PSI:
+		return "pressure", nil
+	case FS:
+		return "filesystems", nil
+	case CGROUP:
+		return "cgroups", nil
+	case LIMITS:
+		return "limits", nil
+	case MEMX:
+		return "memory_extended", nil
+	case SENSORS:
+		return "sensors", nil
+	case GAP:
+		return "gaps", nil
+	case ALERT:
+		return "alerts", nil
 	}
-	return ""
+	return "", ErrUnknownType
 }
 
 type CPUMeasurement struct {
 	Timestamp                       int64   // unix timestamp of measurement
 	User, System, Idle, Nice, Total uint64  // raw values
 	Userp, Systp, Idlep             float64 // percentage calculated with last measurement
+	Latency                         int64   // microseconds between the tick and the measurement
 }
 
-func (c CPUMeasurement) Record() ([]string, error) {
+// IsReference reports whether the percentages are missing, e.g., in the first measurement
+func (c CPUMeasurement) IsReference() bool {
+	return math.IsNaN(c.Userp) || math.IsNaN(c.Systp) || math.IsNaN(c.Idlep)
+}
 
-	anyEq := func(iter []any, to any) bool {
-		for _, x := range iter {
-			if x == to {
-				return true
-			}
-		}
-		return false
-	}
+func (c CPUMeasurement) Record() ([]string, error) {
 
-	if anyEq([]any{c.Userp, c.Systp, c.Idlep}, math.NaN()) {
-		// TODO use color.YellowString and log instead?
-		msg := "found NaN in CPU measurements"
-		color.Yellow(msg)
-		return nil, errors.New(msg)
+	if c.IsReference() {
+		return nil, errors.New("found NaN in CPU measurements")
 	}
 
 	res := []string{
@@ -148,6 +654,7 @@ func (c CPUMeasurement) Record() ([]string, error) {
 		fmt.Sprintf("%.4f", c.Userp),
 		fmt.Sprintf("%.4f", c.Systp),
 		fmt.Sprintf("%.4f", c.Idlep),
+		fmt.Sprintf("%d", c.Latency),
 	}
 
 	return res, nil
@@ -157,6 +664,7 @@ type MemoryMeasurement struct {
 	Timestamp                                                                  int64   // unix timestamp of measurement
 	Free, Total, Active, Cached, Inactive, SwapFree, SwapTotal, SwapUsed, Used uint64  // values in bytes
 	Freep                                                                      float64 // freep => free/total * 100
+	Latency                                                                    int64   // microseconds between the tick and the measurement
 }
 
 func (m MemoryMeasurement) Record() ([]string, error) {
@@ -172,14 +680,40 @@ func (m MemoryMeasurement) Record() ([]string, error) {
 		fmt.Sprintf("%d", m.SwapUsed),
 		fmt.Sprintf("%d", m.Used),
 		fmt.Sprintf("%f", m.Freep),
+		fmt.Sprintf("%d", m.Latency),
 	}, nil
 }
 
+// InterfaceCounters are the counters /proc/net/dev keeps per network interface
+type InterfaceCounters struct {
+	RxBytes, TxBytes     uint64
+	RxPackets, TxPackets uint64
+	RxErrors, TxErrors   uint64
+	RxDropped, TxDropped uint64
+	RxFifo, TxFifo       uint64 // FIFO buffer errors
+	Multicast            uint64 // received multicast packets
+}
+
+// InterfaceStats are the counters of a network interface at a point in time
+type InterfaceStats struct {
+	Name string
+	InterfaceCounters
+	Time time.Time // when the counters were read
+}
+
 type NetworkMeasurement struct {
-	Timestamp        int64
-	Interface        string        // TODO create multiple NetworkMeasurement structs in `monitor.go`, one per interface
-	RxBytes, TxBytes uint64        // bytes received/transmitted since the previous measurement
-	Source           netstat.Stats // to calculate when stored as previous
+	Timestamp                        int64
+	Interface                        string
+	InterfaceCounters                        // increase since the previous measurement
+	RxBytesPerSec, TxBytesPerSec     float64 // rates over the actual time since the previous measurement
+	RxPacketsPerSec, TxPacketsPerSec float64
+	Source                           InterfaceStats // to calculate when stored as previous
+	Latency                          int64          // microseconds between the tick and the measurement
+	First                            bool           // no previous counters (new interface or counter reset), not recorded
+}
+
+func (n NetworkMeasurement) IsReference() bool {
+	return n.First
 }
 
 func (n NetworkMeasurement) Record() ([]string, error) {
@@ -188,5 +722,405 @@ func (n NetworkMeasurement) Record() ([]string, error) {
 		fmt.Sprintf("'%s'", n.Interface),
 		fmt.Sprintf("%d", n.RxBytes),
 		fmt.Sprintf("%d", n.TxBytes),
+		fmt.Sprintf("%d", n.RxPackets),
+		fmt.Sprintf("%d", n.TxPackets),
+		fmt.Sprintf("%d", n.RxErrors),
+		fmt.Sprintf("%d", n.TxErrors),
+		fmt.Sprintf("%d", n.RxDropped),
+		fmt.Sprintf("%d", n.TxDropped),
+		fmt.Sprintf("%d", n.RxFifo),
+		fmt.Sprintf("%d", n.TxFifo),
+		fmt.Sprintf("%d", n.Multicast),
+		fmt.Sprintf("%.2f", n.RxBytesPerSec),
+		fmt.Sprintf("%.2f", n.TxBytesPerSec),
+		fmt.Sprintf("%.2f", n.RxPacketsPerSec),
+		fmt.Sprintf("%.2f", n.TxPacketsPerSec),
+		fmt.Sprintf("%d", n.Latency),
 	}, nil
 }
+
+// ProtocolCounters are the TCP and UDP counters from /proc/net/snmp and /proc/net/netstat
+type ProtocolCounters struct {
+	ActiveOpens, PassiveOpens uint64 // connections opened by this host/by peers
+	AttemptFails, EstabResets uint64 // failed connection attempts, reset established connections
+	OutRsts, RetransSegs      uint64 // sent resets, retransmitted segments
+	ListenDrops               uint64 // SYNs dropped by listening sockets
+	UDPInErrors               uint64
+	UDPRcvbufErrors           uint64 // datagrams dropped because the receive buffer was full
+}
+
+type ProtocolMeasurement struct {
+	Timestamp             int64
+	ProtocolCounters                       // increase since the previous measurement
+	Established, TimeWait uint64           // current number of TCP connections in ESTABLISHED/TIME_WAIT
+	Source                ProtocolCounters // to calculate when stored as previous
+	Latency               int64            // microseconds between the tick and the measurement
+	First                 bool             // no previous counters, not recorded
+}
+
+func (p ProtocolMeasurement) IsReference() bool {
+	return p.First
+}
+
+func (p ProtocolMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", p.Timestamp),
+		fmt.Sprintf("%d", p.ActiveOpens),
+		fmt.Sprintf("%d", p.PassiveOpens),
+		fmt.Sprintf("%d", p.AttemptFails),
+		fmt.Sprintf("%d", p.EstabResets),
+		fmt.Sprintf("%d", p.OutRsts),
+		fmt.Sprintf("%d", p.RetransSegs),
+		fmt.Sprintf("%d", p.ListenDrops),
+		fmt.Sprintf("%d", p.UDPInErrors),
+		fmt.Sprintf("%d", p.UDPRcvbufErrors),
+		fmt.Sprintf("%d", p.Established),
+		fmt.Sprintf("%d", p.TimeWait),
+		fmt.Sprintf("%d", p.Latency),
+	}, nil
+}
+
+// SchedulerCounters are the counters from /proc/stat the load measurement's rates are calculated from
+type SchedulerCounters struct {
+	ContextSwitches, Interrupts, Forks uint64
+	Time                               time.Time // when the counters were read
+}
+
+type LoadMeasurement struct {
+	Timestamp                                            int64
+	Load1, Load5, Load15                                 float64 // load averages over 1, 5 and 15 minutes
+	Running, Blocked                                     uint64  // processes that are runnable/blocked on I/O
+	ContextSwitchesPerSec, InterruptsPerSec, ForksPerSec float64 // rates over the actual time since the previous measurement
+	Source                                               SchedulerCounters
+	Latency                                              int64 // microseconds between the tick and the measurement
+	First                                                bool  // no previous counters, not recorded
+}
+
+func (l LoadMeasurement) IsReference() bool {
+	return l.First
+}
+
+func (l LoadMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", l.Timestamp),
+		fmt.Sprintf("%.2f", l.Load1),
+		fmt.Sprintf("%.2f", l.Load5),
+		fmt.Sprintf("%.2f", l.Load15),
+		fmt.Sprintf("%d", l.Running),
+		fmt.Sprintf("%d", l.Blocked),
+		fmt.Sprintf("%.2f", l.ContextSwitchesPerSec),
+		fmt.Sprintf("%.2f", l.InterruptsPerSec),
+		fmt.Sprintf("%.2f", l.ForksPerSec),
+		fmt.Sprintf("%d", l.Latency),
+	}, nil
+}
+
+// PressureStats are the total stall times of a resource from /proc/pressure
+type PressureStats struct {
+	Resource             string // cpu, memory or io
+	SomeTotal, FullTotal uint64 // microseconds some/all non-idle tasks were stalled on the resource
+}
+
+type PressureMeasurement struct {
+	Timestamp            int64
+	Resource             string
+	SomeAvg10, SomeAvg60 float64 // percentage of time some tasks were stalled over the last 10/60 seconds
+	SomeStall            uint64  // microseconds some tasks were stalled since the previous measurement
+	FullAvg10, FullAvg60 float64 // same for all non-idle tasks at once
+	FullStall            uint64
+	Source               PressureStats // to calculate when stored as previous
+	Latency              int64         // microseconds between the tick and the measurement
+	First                bool          // no previous totals, not recorded
+}
+
+func (p PressureMeasurement) IsReference() bool {
+	return p.First
+}
+
+func (p PressureMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", p.Timestamp),
+		fmt.Sprintf("'%s'", p.Resource),
+		fmt.Sprintf("%.2f", p.SomeAvg10),
+		fmt.Sprintf("%.2f", p.SomeAvg60),
+		fmt.Sprintf("%d", p.SomeStall),
+		fmt.Sprintf("%.2f", p.FullAvg10),
+		fmt.Sprintf("%.2f", p.FullAvg60),
+		fmt.Sprintf("%d", p.FullStall),
+		fmt.Sprintf("%d", p.Latency),
+	}, nil
+}
+
+type FilesystemMeasurement struct {
+	Timestamp                      int64
+	Mountpoint, Type, Device       string
+	Total, Used, Available         uint64  // bytes, available is what unprivileged users can still use
+	Usedp                          float64 // used / (used + available) * 100, like df
+	Inodes, InodesUsed, InodesFree uint64
+	Latency                        int64 // microseconds between the tick and the measurement
+}
+
+func (f FilesystemMeasurement) Record() ([]string, error) {
+
+	// text values are quoted, so they can't contain quotes
+	quote := func(s string) string {
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", ""))
+	}
+
+	return []string{
+		fmt.Sprintf("%d", f.Timestamp),
+		quote(f.Mountpoint),
+		quote(f.Type),
+		quote(f.Device),
+		fmt.Sprintf("%d", f.Total),
+		fmt.Sprintf("%d", f.Used),
+		fmt.Sprintf("%d", f.Available),
+		fmt.Sprintf("%.2f", f.Usedp),
+		fmt.Sprintf("%d", f.Inodes),
+		fmt.Sprintf("%d", f.InodesUsed),
+		fmt.Sprintf("%d", f.InodesFree),
+		fmt.Sprintf("%d", f.Latency),
+	}, nil
+}
+
+// CgroupCounters are the cumulative counters of a cgroup from cpu.stat and io.stat
+type CgroupCounters struct {
+	Usage, User, System   uint64 // CPU time in microseconds
+	ReadBytes, WriteBytes uint64 // summed over all devices
+	ReadOps, WriteOps     uint64
+	Time                  time.Time // when the counters were read
+}
+
+type CgroupMeasurement struct {
+	Timestamp             int64
+	Cgroup                string  // path relative to the cgroup root, e.g., /system.slice/docker.service
+	Usage, User, System   uint64  // CPU time in microseconds since the previous measurement
+	Cpup                  float64 // CPU usage in percent of one CPU
+	MemoryCurrent         uint64  // bytes
+	Anon, File            uint64  // anonymous and page cache memory in bytes
+	ReadBytes, WriteBytes uint64  // since the previous measurement
+	ReadOps, WriteOps     uint64
+	Pids                  uint64
+	Source                CgroupCounters // to calculate when stored as previous
+	Latency               int64          // microseconds between the tick and the measurement
+	First                 bool           // no previous counters (new cgroup or counter reset), not recorded
+}
+
+func (c CgroupMeasurement) IsReference() bool {
+	return c.First
+}
+
+func (c CgroupMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", c.Timestamp),
+		fmt.Sprintf("'%s'", strings.ReplaceAll(c.Cgroup, "'", "")),
+		fmt.Sprintf("%d", c.Usage),
+		fmt.Sprintf("%d", c.User),
+		fmt.Sprintf("%d", c.System),
+		fmt.Sprintf("%.2f", c.Cpup),
+		fmt.Sprintf("%d", c.MemoryCurrent),
+		fmt.Sprintf("%d", c.Anon),
+		fmt.Sprintf("%d", c.File),
+		fmt.Sprintf("%d", c.ReadBytes),
+		fmt.Sprintf("%d", c.WriteBytes),
+		fmt.Sprintf("%d", c.ReadOps),
+		fmt.Sprintf("%d", c.WriteOps),
+		fmt.Sprintf("%d", c.Pids),
+		fmt.Sprintf("%d", c.Latency),
+	}, nil
+}
+
+// ThrottlingCounters are the cumulative CPU counters of a cgroup's cpu.stat
+type ThrottlingCounters struct {
+	Usage              uint64 // CPU time in microseconds
+	Periods, Throttled uint64 // enforcement periods, periods in which the cgroup was throttled
+	ThrottledUsec      uint64
+	Time               time.Time // when the counters were read
+}
+
+// LimitsMeasurement is the resource usage of the cgroup StatTrack runs in, relative to its effective limits.
+// The limits are the host's resources if neither the cgroup nor its parents set one.
+type LimitsMeasurement struct {
+	Timestamp          int64
+	Cgroup             string
+	MemoryCurrent      uint64  // bytes
+	MemoryLimit        uint64  // bytes, memory.max or the host's total memory
+	Memoryp            float64 // current / limit * 100
+	CPUUsage           float64 // CPUs used since the previous measurement
+	CPULimit           float64 // CPUs, the cpu.max quota divided by the period or the number of CPUs
+	Cpup               float64 // usage / limit * 100
+	Periods, Throttled uint64  // since the previous measurement
+	ThrottledUsec      uint64
+	Source             ThrottlingCounters // to calculate when stored as previous
+	Latency            int64              // microseconds between the tick and the measurement
+	First              bool               // no previous counters, not recorded
+}
+
+func (l LimitsMeasurement) IsReference() bool {
+	return l.First
+}
+
+func (l LimitsMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", l.Timestamp),
+		fmt.Sprintf("'%s'", strings.ReplaceAll(l.Cgroup, "'", "")),
+		fmt.Sprintf("%d", l.MemoryCurrent),
+		fmt.Sprintf("%d", l.MemoryLimit),
+		fmt.Sprintf("%.2f", l.Memoryp),
+		fmt.Sprintf("%.4f", l.CPUUsage),
+		fmt.Sprintf("%.2f", l.CPULimit),
+		fmt.Sprintf("%.2f", l.Cpup),
+		fmt.Sprintf("%d", l.Periods),
+		fmt.Sprintf("%d", l.Throttled),
+		fmt.Sprintf("%d", l.ThrottledUsec),
+		fmt.Sprintf("%d", l.Latency),
+	}, nil
+}
+
+// VMCounters are the cumulative counters from /proc/vmstat
+type VMCounters struct {
+	Faults, MajorFaults uint64    // page faults, major faults required I/O
+	Scanned, Stolen     uint64    // pages scanned and reclaimed
+	SwapIn, SwapOut     uint64    // pages
+	Time                time.Time // when the counters were read
+}
+
+type ExtendedMemoryMeasurement struct {
+	Timestamp                                    int64
+	Available, Buffers, Cached, Dirty, Writeback uint64     // bytes
+	Slab, SReclaimable, Shmem, AnonHugePages     uint64     // bytes
+	HugePagesTotal, HugePagesFree                uint64     // number of huge pages
+	HugePageSize                                 uint64     // bytes
+	MinorFaults, MajorFaults                     uint64     // since the previous measurement
+	Scanned, Stolen                              uint64     // pgscan and pgsteal since the previous measurement
+	SwapInPerSec, SwapOutPerSec                  float64    // pages per second since the previous measurement
+	Source                                       VMCounters // to calculate when stored as previous
+	Latency                                      int64      // microseconds between the tick and the measurement
+	First                                        bool       // no previous counters, not recorded
+}
+
+func (e ExtendedMemoryMeasurement) IsReference() bool {
+	return e.First
+}
+
+func (e ExtendedMemoryMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", e.Timestamp),
+		fmt.Sprintf("%d", e.Available),
+		fmt.Sprintf("%d", e.Buffers),
+		fmt.Sprintf("%d", e.Cached),
+		fmt.Sprintf("%d", e.Dirty),
+		fmt.Sprintf("%d", e.Writeback),
+		fmt.Sprintf("%d", e.Slab),
+		fmt.Sprintf("%d", e.SReclaimable),
+		fmt.Sprintf("%d", e.Shmem),
+		fmt.Sprintf("%d", e.AnonHugePages),
+		fmt.Sprintf("%d", e.HugePagesTotal),
+		fmt.Sprintf("%d", e.HugePagesFree),
+		fmt.Sprintf("%d", e.HugePageSize),
+		fmt.Sprintf("%d", e.MinorFaults),
+		fmt.Sprintf("%d", e.MajorFaults),
+		fmt.Sprintf("%d", e.Scanned),
+		fmt.Sprintf("%d", e.Stolen),
+		fmt.Sprintf("%.2f", e.SwapInPerSec),
+		fmt.Sprintf("%.2f", e.SwapOutPerSec),
+		fmt.Sprintf("%d", e.Latency),
+	}, nil
+}
+
+// kinds of sensor measurements
+const (
+	Temperature = "temperature" // degrees Celsius
+	Fan         = "fan"         // RPM
+	Frequency   = "frequency"   // MHz
+)
+
+// SensorMeasurement is the reading of one sensor, e.g., a temperature or a CPU's clock frequency
+type SensorMeasurement struct {
+	Timestamp int64
+	Sensor    string  // e.g., coretemp/Package id 0 or cpu3
+	Kind      string  // Temperature, Fan or Frequency
+	Value     float64 // in the kind's unit
+	Latency   int64   // microseconds between the tick and the measurement
+}
+
+func (s SensorMeasurement) Record() ([]string, error) {
+	return []string{
+		fmt.Sprintf("%d", s.Timestamp),
+		fmt.Sprintf("'%s'", strings.ReplaceAll(s.Sensor, "'", "")),
+		fmt.Sprintf("'%s'", s.Kind),
+		fmt.Sprintf("%.2f", s.Value),
+		fmt.Sprintf("%d", s.Latency),
+	}, nil
+}
+
+// Gap marks a time span without measurements of a type, either because ticks were missed
+// (the system stalled or the collector was too slow) or because collecting failed
+type Gap struct {
+	Timestamp  int64           // unix timestamp of when the gap was detected
+	Type       MeasurementType // type of the missing measurements
+	Start, End int64           // unix timestamps in milliseconds of the last tick before and the first tick after the gap
+	Missed     int64           // number of missing samples
+	Reason     string
+}
+
+func (g Gap) Record() ([]string, error) {
+
+	name, err := GetFileName(g.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		fmt.Sprintf("%d", g.Timestamp),
+		fmt.Sprintf("'%s'", name),
+		fmt.Sprintf("%d", g.Start),
+		fmt.Sprintf("%d", g.End),
+		fmt.Sprintf("%d", g.Missed),
+		fmt.Sprintf("'%s'", strings.ReplaceAll(g.Reason, "'", "")),
+	}, nil
+}
+
+// states of an alert
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+// Alert is an event of an alerting rule, which fires when its condition has been true for long enough
+// and resolves when the condition is false again
+type Alert struct {
+	Timestamp int64   // unix timestamp of the event
+	Rule      string  // the rule's name
+	Key       string  // the measurement's text values, e.g., the network interface, empty for most types
+	State     string  // Firing or Resolved
+	Value     float64 // the value that caused the event
+	Since     int64   // unix timestamp of when the condition became true
+}
+
+func (a Alert) Record() ([]string, error) {
+
+	// text values are quoted, so they can't contain quotes
+	quote := func(s string) string {
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", ""))
+	}
+
+	return []string{
+		fmt.Sprintf("%d", a.Timestamp),
+		quote(a.Rule),
+		quote(a.Key),
+		quote(a.State),
+		fmt.Sprintf("%f", a.Value),
+		fmt.Sprintf("%d", a.Since),
+	}, nil
+}
+
+// Row is a measurement of any type that only consists of its record, e.g., one received from another host
+type Row struct {
+	Type   MeasurementType
+	Values []string // as returned by Record of the original measurement
+}
+
+func (r Row) Record() ([]string, error) {
+	return r.Values, nil
+}
@@ -0,0 +1,264 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// directory in sysfs
+const sysFsCgroup = "fs/cgroup"
+
+// cgroupRoot returns where the cgroup v2 hierarchy is mounted in the sysfs at `sys`,
+// /sys/fs/cgroup or /sys/fs/cgroup/unified on systems that still mount cgroup v1 controllers
+func cgroupRoot(sys string) (string, error) {
+	for _, root := range []string{path.Join(sys, sysFsCgroup), path.Join(sys, sysFsCgroup, "unified")} {
+		if _, err := os.Stat(path.Join(root, "cgroup.controllers")); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 hierarchy mounted at %s", path.Join(sys, sysFsCgroup))
+}
+
+// listCgroups returns the configured cgroups and the children of the configured parents, relative to `root`
+func listCgroups(root string) []string {
+
+	cgroups := slices.Clone(config.Cgroups)
+
+	for _, parent := range config.CgroupParents {
+		entries, err := os.ReadDir(path.Join(root, parent))
+		if err != nil {
+			slog.Debug("could not list cgroup children", "cgroup", parent, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				cgroups = append(cgroups, path.Join("/", parent, entry.Name()))
+			}
+		}
+	}
+
+	for i := range cgroups {
+		cgroups[i] = path.Join("/", cgroups[i])
+	}
+	slices.Sort(cgroups)
+
+	return slices.Compact(cgroups)
+}
+
+// readKeyValues reads flat keyed files like cpu.stat or memory.stat, whose lines look like
+//
+//	usage_usec 1234
+//
+// A missing file is not an error, not every controller is enabled in every cgroup.
+func readKeyValues(file string) (map[string]uint64, error) {
+
+	values := make(map[string]uint64)
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		values[fields[0]], err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %w", file, fields[0], err)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readValue reads single value files like memory.current, a missing file reads as zero
+func readValue(file string) (uint64, error) {
+
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return value, nil
+}
+
+// readIOStat sums the counters of all devices in an io.stat file, whose lines look like
+//
+//	8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func readIOStat(file string) (map[string]uint64, error) {
+
+	sums := make(map[string]uint64)
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for _, field := range fields[min(1, len(fields)):] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %w", file, key, err)
+			}
+			sums[key] += n
+		}
+	}
+
+	return sums, scanner.Err()
+}
+
+// readCgroup reads the current values of a cgroup.
+// The measurement's absolute values and Source are set, the relative values are left for the caller.
+func readCgroup(root, cgroup string) (m measurements.CgroupMeasurement, err error) {
+
+	dir := path.Join(root, cgroup)
+
+	// the cgroup could have been removed since it was listed
+	if _, err := os.Stat(dir); err != nil {
+		return m, err
+	}
+
+	m.Cgroup = cgroup
+	m.Source.Time = time.Now()
+
+	cpuStat, err := readKeyValues(path.Join(dir, "cpu.stat"))
+	if err != nil {
+		return m, err
+	}
+	memoryStat, err := readKeyValues(path.Join(dir, "memory.stat"))
+	if err != nil {
+		return m, err
+	}
+	ioStat, err := readIOStat(path.Join(dir, "io.stat"))
+	if err != nil {
+		return m, err
+	}
+	m.MemoryCurrent, err = readValue(path.Join(dir, "memory.current"))
+	if err != nil {
+		return m, err
+	}
+	m.Pids, err = readValue(path.Join(dir, "pids.current"))
+	if err != nil {
+		return m, err
+	}
+
+	m.Anon = memoryStat["anon"]
+	m.File = memoryStat["file"]
+	m.Source.Usage = cpuStat["usage_usec"]
+	m.Source.User = cpuStat["user_usec"]
+	m.Source.System = cpuStat["system_usec"]
+	m.Source.ReadBytes = ioStat["rbytes"]
+	m.Source.WriteBytes = ioStat["wbytes"]
+	m.Source.ReadOps = ioStat["rios"]
+	m.Source.WriteOps = ioStat["wios"]
+
+	return m, nil
+}
+
+func cgroups(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	root, err := cgroupRoot(config.SysRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := make(map[string]measurements.CgroupMeasurement)
+	for _, m := range previous {
+		if c, ok := m.(measurements.CgroupMeasurement); ok {
+			prev[c.Cgroup] = c
+		}
+	}
+
+	var result []measurements.Measurement
+
+	for _, cgroup := range listCgroups(root) {
+
+		m, err := readCgroup(root, cgroup)
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Debug("cgroup does not exist", "cgroup", cgroup)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		m.Timestamp = now.Unix()
+		m.Latency = now.Sub(tick).Microseconds()
+
+		// like network interfaces, cgroups come and go, their first measurement is only kept as reference
+		prevm, ok := prev[cgroup]
+		if !ok {
+			slog.Debug("no previous cgroup measurement", "cgroup", cgroup)
+			m.First = true
+			result = append(result, m)
+			continue
+		}
+
+		sub := func(prev, curr uint64) uint64 {
+			d, valid := counterDelta(prev, curr)
+			ok = ok && valid
+			return d
+		}
+
+		m.Usage = sub(prevm.Source.Usage, m.Source.Usage)
+		m.User = sub(prevm.Source.User, m.Source.User)
+		m.System = sub(prevm.Source.System, m.Source.System)
+		m.ReadBytes = sub(prevm.Source.ReadBytes, m.Source.ReadBytes)
+		m.WriteBytes = sub(prevm.Source.WriteBytes, m.Source.WriteBytes)
+		m.ReadOps = sub(prevm.Source.ReadOps, m.Source.ReadOps)
+		m.WriteOps = sub(prevm.Source.WriteOps, m.Source.WriteOps)
+		if !ok {
+			// most likely, the cgroup was removed and created again
+			slog.Warn("cgroup counters were reset, skipping measurement", "cgroup", cgroup)
+			m.First = true
+		}
+
+		if elapsed := m.Source.Time.Sub(prevm.Source.Time); elapsed > 0 {
+			m.Cpup = float64(m.Usage) / float64(elapsed.Microseconds()) * 100
+		}
+
+		result = append(result, m)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("none of the cgroups to record exists in %s", root)
+	}
+
+	return result, nil
+}
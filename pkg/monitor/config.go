@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"path"
+	"strings"
+)
+
+// Config holds the collectors' settings. It is set with Configure before the monitors are started.
+type Config struct {
+	ProcRoot string // where procfs is mounted, usually /proc
+	SysRoot  string // where sysfs is mounted, usually /sys
+
+	Interfaces  Filter // network interfaces to record
+	Mountpoints Filter // mounted filesystems to record, by mountpoint
+	FSTypes     Filter // mounted filesystems to record, by type
+
+	// cgroups to record, relative to the cgroup root: the Cgroups themselves and the children of the CgroupParents
+	Cgroups, CgroupParents []string
+}
+
+// DefaultConfig returns the settings used if Configure isn't called
+func DefaultConfig() Config {
+	return Config{
+		ProcRoot:      "/proc",
+		SysRoot:       "/sys",
+		Interfaces:    Filter{Exclude: Patterns{"lo"}},
+		CgroupParents: []string{"/"},
+	}
+}
+
+var config = DefaultConfig()
+
+// Configure sets the collectors' settings, it must not be called while monitors are running
+func Configure(c Config) {
+	config = c
+}
+
+// procPath returns the path of a file in procfs, e.g., procPath("net/dev")
+func procPath(elem ...string) string {
+	return path.Join(append([]string{config.ProcRoot}, elem...)...)
+}
+
+// foreignProc reports whether ProcRoot isn't this process's own procfs, e.g., the host's mounted into a container.
+// Paths found in it, like mountpoints, are then relative to the root of its init process, see procPath("1/root").
+func foreignProc() bool {
+	return path.Clean(config.ProcRoot) != "/proc"
+}
+
+// sysPath returns the path of a file in sysfs, e.g., sysPath("fs/cgroup")
+func sysPath(elem ...string) string {
+	return path.Join(append([]string{config.SysRoot}, elem...)...)
+}
+
+// Patterns is a list of shell patterns (see path.Match), it can be used as a command line flag
+type Patterns []string
+
+func (p *Patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set adds a pattern, a comma-separated list adds several
+func (p *Patterns) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+// Filter selects names by patterns. A name is selected if it matches one of the Include patterns
+// (or there are none) and none of the Exclude patterns.
+type Filter struct {
+	Include, Exclude Patterns
+}
+
+// Match reports whether the filter selects `name`
+func (f Filter) Match(name string) bool {
+
+	matches := func(patterns Patterns) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(f.Include) > 0 && !matches(f.Include) {
+		return false
+	}
+
+	return !matches(f.Exclude)
+}
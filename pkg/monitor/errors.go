@@ -0,0 +1,28 @@
+package monitor
+
+import (
+	"sync"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// number of failed collections per measurement type
+var (
+	errorsMutex sync.Mutex
+	errorCounts = make(map[measurements.MeasurementType]uint64)
+)
+
+// countError counts a failed collection and returns the type's number of failures so far
+func countError(mT measurements.MeasurementType) uint64 {
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+	errorCounts[mT]++
+	return errorCounts[mT]
+}
+
+// Errors returns how often collecting measurements of a type has failed
+func Errors(mT measurements.MeasurementType) uint64 {
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+	return errorCounts[mT]
+}
@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// files in procfs, the mounts of this process or, in a foreign procfs, those of its init process
+const (
+	procMounts     = "self/mounts"
+	procInitMounts = "1/mounts"
+	procInitRoot   = "1/root"
+)
+
+// mount is an entry of /proc/self/mounts
+type mount struct {
+	device, mountpoint, fsType string
+}
+
+// readMounts reads the mounted filesystems, whose lines look like
+//
+//	/dev/sda1 /mnt/my\040disk ext4 rw,relatime 0 0
+//
+// with spaces and other special characters escaped as octal numbers
+func readMounts(path string) ([]mount, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []mount
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mounts = append(mounts, mount{
+			device:     unescapeMount(fields[0]),
+			mountpoint: unescapeMount(fields[1]),
+			fsType:     fields[2],
+		})
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMount replaces the octal escapes in /proc/self/mounts, e.g., \040 for a space
+func unescapeMount(s string) string {
+
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func filesystems(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	// `previous` is not required to calculate filesystem stats
+
+	// the mountpoints of the host's init process are only reachable through its root directory,
+	// this process's own mount namespace has other filesystems (or none) mounted there
+	mountsFile, mountRoot := procPath(procMounts), "/"
+	if foreignProc() {
+		mountsFile, mountRoot = procPath(procInitMounts), procPath(procInitRoot)
+	}
+
+	mounts, err := readMounts(mountsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []measurements.Measurement
+	seen := make(map[string]bool)
+
+	// later mounts hide earlier ones on the same mountpoint
+	for i := len(mounts) - 1; i >= 0; i-- {
+
+		mnt := mounts[i]
+		if seen[mnt.mountpoint] {
+			continue
+		}
+		seen[mnt.mountpoint] = true
+
+		if !config.Mountpoints.Match(mnt.mountpoint) || !config.FSTypes.Match(mnt.fsType) {
+			continue
+		}
+
+		var stat unix.Statfs_t
+		err := unix.Statfs(path.Join(mountRoot, mnt.mountpoint), &stat)
+		if err != nil {
+			// e.g., a mountpoint that isn't accessible, the other filesystems are still recorded
+			slog.Debug("could not get filesystem stats", "mountpoint", mnt.mountpoint, "err", err)
+			continue
+		}
+
+		// pseudo filesystems like proc or sysfs don't have any blocks
+		if stat.Blocks == 0 {
+			continue
+		}
+
+		now := time.Now()
+
+		blockSize := uint64(stat.Frsize)
+		if blockSize == 0 {
+			blockSize = uint64(stat.Bsize)
+		}
+
+		used := (stat.Blocks - stat.Bfree) * blockSize
+		available := stat.Bavail * blockSize
+
+		var usedp float64
+		if used+available > 0 {
+			usedp = float64(used) / float64(used+available) * 100
+		}
+
+		result = append(result, measurements.FilesystemMeasurement{
+			Timestamp:  now.Unix(),
+			Mountpoint: mnt.mountpoint,
+			Type:       mnt.fsType,
+			Device:     mnt.device,
+			Total:      stat.Blocks * blockSize,
+			Used:       used,
+			Available:  available,
+			Usedp:      usedp,
+			Inodes:     stat.Files,
+			InodesUsed: stat.Files - stat.Ffree,
+			InodesFree: stat.Ffree,
+			Latency:    now.Sub(tick).Microseconds(),
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no filesystem in %s matches the filters", mountsFile)
+	}
+
+	return result, nil
+}
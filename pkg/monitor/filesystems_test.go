@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+func TestFilesystems(t *testing.T) {
+
+	// a foreign procfs, its init process's mountpoints are found below its root directory
+	useFixtures(t, "testdata/proc", "testdata/sys")
+
+	result, err := filesystems(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// proc has no blocks and /missing doesn't exist below the root, the later mount comes first
+	expected := []measurements.FilesystemMeasurement{
+		{Mountpoint: "/mnt/my disk", Type: "xfs", Device: "/dev/sdb1"},
+		{Mountpoint: "/", Type: "ext4", Device: "/dev/sda1"},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d filesystems, got %d: %v", len(expected), len(result), result)
+	}
+	for i, mm := range result {
+		m := mm.(measurements.FilesystemMeasurement)
+		if m.Mountpoint != expected[i].Mountpoint || m.Type != expected[i].Type || m.Device != expected[i].Device {
+			t.Errorf("expected %+v, got %+v", expected[i], m)
+		}
+		// the sizes are those of the filesystem holding the fixtures
+		if m.Total == 0 || m.Used > m.Total {
+			t.Errorf("unexpected sizes of %s: %+v", m.Mountpoint, m)
+		}
+	}
+}
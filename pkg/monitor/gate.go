@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// buffered is a measurement waiting in front of a closed gate
+type buffered struct {
+	value    measurements.Measurement
+	received time.Time
+}
+
+// Gate holds back the measurements from `in` until `open` is closed and forwards them to `out` afterwards.
+// Until then, the measurements of the last `keep` are buffered like an oscilloscope's pre-trigger buffer
+// and sent first once the gate opens, older ones are dropped.
+func Gate(ctx context.Context, in <-chan measurements.Measurement, out chan<- measurements.Measurement, open <-chan struct{}, keep time.Duration) {
+
+	var buffer []buffered
+
+	// wait for the trigger
+	for waiting := true; waiting; {
+		select {
+		case value := <-in:
+			{
+				if keep <= 0 {
+					continue
+				}
+				now := time.Now()
+				buffer = append(buffer, buffered{value, now})
+				for len(buffer) > 0 && now.Sub(buffer[0].received) > keep {
+					buffer = buffer[1:]
+				}
+			}
+		case <-open:
+			{
+				waiting = false
+			}
+		case <-ctx.Done():
+			{
+				return
+			}
+		}
+	}
+
+	for _, b := range buffer {
+		select {
+		case out <- b.value:
+		case <-ctx.Done():
+			return
+		}
+	}
+	buffer = nil
+
+	// pass everything through
+	for {
+		select {
+		case value := <-in:
+			{
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			{
+				return
+			}
+		}
+	}
+}
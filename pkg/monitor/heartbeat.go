@@ -0,0 +1,47 @@
+package monitor
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// when each running monitor has last handled a tick
+var (
+	heartbeatsMutex sync.Mutex
+	heartbeats      = make(map[measurements.MeasurementType]time.Time)
+)
+
+// beat records that the monitor of a type has handled a tick
+func beat(mT measurements.MeasurementType, t time.Time) {
+	heartbeatsMutex.Lock()
+	defer heartbeatsMutex.Unlock()
+	heartbeats[mT] = t
+}
+
+// stopBeating forgets a monitor that is done, so it doesn't count as stalled
+func stopBeating(mT measurements.MeasurementType) {
+	heartbeatsMutex.Lock()
+	defer heartbeatsMutex.Unlock()
+	delete(heartbeats, mT)
+}
+
+// Stalled returns the running monitors that haven't handled a tick for longer than `timeout`,
+// e.g., because a collector hangs on an unresponsive filesystem
+func Stalled(timeout time.Duration) []measurements.MeasurementType {
+
+	heartbeatsMutex.Lock()
+	defer heartbeatsMutex.Unlock()
+
+	var stalled []measurements.MeasurementType
+	for mT, last := range heartbeats {
+		if time.Since(last) > timeout {
+			stalled = append(stalled, mT)
+		}
+	}
+	slices.Sort(stalled)
+
+	return stalled
+}
@@ -0,0 +1,243 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// file in procfs
+const procSelfCgroup = "self/cgroup"
+
+// the procfs and sysfs of stattrack's own namespaces. The limits are those of stattrack's own cgroup, so they're
+// read from here even if -proc-root and -sys-root point to the host's, where `self` wouldn't be this process.
+var ownProcRoot, ownSysRoot = "/proc", "/sys"
+
+// ownCgroup returns the cgroup v2 path of this process from /proc/self/cgroup, whose v2 line looks like
+//
+//	0::/system.slice/docker-1234.scope
+//
+// Inside a container with its own cgroup namespace, this is usually /.
+func ownCgroup(file string) (string, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if cgroup, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return cgroup, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%s: not in a cgroup v2 hierarchy", file)
+}
+
+// readMax reads files like memory.max, which contain either a number or "max".
+// `ok` is false for "max" and missing files, i.e., if there is no limit.
+func readMax(file string) (value uint64, ok bool, err error) {
+
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	text := strings.TrimSpace(string(content))
+	if text == "max" {
+		return 0, false, nil
+	}
+
+	value, err = strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return value, true, nil
+}
+
+// readCPUMax reads cpu.max, which looks like "<quota> <period>" or "max <period>",
+// and returns the quota in CPUs. `ok` is false if there is no quota.
+func readCPUMax(file string) (cpus float64, ok bool, err error) {
+
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		return 0, false, fmt.Errorf("%s: unexpected content %q", file, content)
+	}
+	if fields[0] == "max" {
+		return 0, false, nil
+	}
+
+	quota, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", file, err)
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || period == 0 {
+		return 0, false, fmt.Errorf("%s: invalid period %q", file, fields[1])
+	}
+
+	return float64(quota) / float64(period), true, nil
+}
+
+// effectiveLimits walks from `cgroup` up to the root and returns the tightest memory and CPU limits,
+// the host's resources if there are none, and the cgroup that sets the CPU limit (or `cgroup` itself)
+func effectiveLimits(root, cgroup string) (memoryLimit uint64, cpuLimit float64, cpuCgroup string, err error) {
+
+	memoryLimit, cpuLimit, cpuCgroup = math.MaxUint64, math.Inf(1), cgroup
+
+	for current := cgroup; ; current = path.Dir(current) {
+
+		dir := path.Join(root, current)
+
+		memory, ok, err := readMax(path.Join(dir, "memory.max"))
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if ok && memory < memoryLimit {
+			memoryLimit = memory
+		}
+
+		cpus, ok, err := readCPUMax(path.Join(dir, "cpu.max"))
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if ok && cpus < cpuLimit {
+			cpuLimit, cpuCgroup = cpus, current
+		}
+
+		if current == "/" || current == "." {
+			break
+		}
+	}
+
+	if memoryLimit == math.MaxUint64 {
+		meminfo, err := readMeminfo(path.Join(ownProcRoot, procMeminfo))
+		if err != nil {
+			return 0, 0, "", err
+		}
+		memoryLimit = meminfo["MemTotal"]
+	}
+
+	if cpus := float64(runtime.NumCPU()); cpus < cpuLimit {
+		cpuLimit = cpus
+	}
+
+	return memoryLimit, cpuLimit, cpuCgroup, nil
+}
+
+func limits(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	root, err := cgroupRoot(ownSysRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	cgroup, err := ownCgroup(path.Join(ownProcRoot, procSelfCgroup))
+	if err != nil {
+		return nil, err
+	}
+
+	// the limits are read every time, they can be changed while the container is running
+	memoryLimit, cpuLimit, cpuCgroup, err := effectiveLimits(root, cgroup)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryCurrent, err := readValue(path.Join(root, cgroup, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+
+	// throttling is counted in the cgroup whose quota is enforced
+	usage, err := readKeyValues(path.Join(root, cgroup, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	throttling, err := readKeyValues(path.Join(root, cpuCgroup, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m := measurements.LimitsMeasurement{
+		Timestamp:     now.Unix(),
+		Cgroup:        cgroup,
+		MemoryCurrent: memoryCurrent,
+		MemoryLimit:   memoryLimit,
+		CPULimit:      cpuLimit,
+		Source: measurements.ThrottlingCounters{
+			Usage:         usage["usage_usec"],
+			Periods:       throttling["nr_periods"],
+			Throttled:     throttling["nr_throttled"],
+			ThrottledUsec: throttling["throttled_usec"],
+			Time:          now,
+		},
+		Latency: now.Sub(tick).Microseconds(),
+	}
+	if memoryLimit > 0 {
+		m.Memoryp = float64(memoryCurrent) / float64(memoryLimit) * 100
+	}
+
+	// the CPU usage and throttling need the previous counters
+	var prev measurements.LimitsMeasurement
+	ok := len(previous) > 0
+	if ok {
+		prev, ok = previous[0].(measurements.LimitsMeasurement)
+	}
+	if !ok {
+		slog.Debug("no previous limits measurement, cannot compute relative values")
+		m.First = true
+		return []measurements.Measurement{m}, nil
+	}
+
+	sub := func(prev, curr uint64) uint64 {
+		d, valid := counterDelta(prev, curr)
+		ok = ok && valid
+		return d
+	}
+
+	used := sub(prev.Source.Usage, m.Source.Usage)
+	m.Periods = sub(prev.Source.Periods, m.Source.Periods)
+	m.Throttled = sub(prev.Source.Throttled, m.Source.Throttled)
+	m.ThrottledUsec = sub(prev.Source.ThrottledUsec, m.Source.ThrottledUsec)
+	if !ok {
+		slog.Warn("cgroup counters were reset, skipping measurement", "cgroup", cgroup)
+		m.First = true
+	}
+
+	if elapsed := m.Source.Time.Sub(prev.Source.Time); elapsed > 0 {
+		m.CPUUsage = float64(used) / float64(elapsed.Microseconds())
+	}
+	if cpuLimit > 0 {
+		m.Cpup = m.CPUUsage / cpuLimit * 100
+	}
+
+	return []measurements.Measurement{m}, nil
+}
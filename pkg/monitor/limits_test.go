@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+func TestLimits(t *testing.T) {
+
+	// the limits are read from stattrack's own procfs and sysfs, not from -proc-root and -sys-root
+	useFixtures(t, "/nonexistent/proc", "/nonexistent/sys")
+	previousProc, previousSys := ownProcRoot, ownSysRoot
+	t.Cleanup(func() { ownProcRoot, ownSysRoot = previousProc, previousSys })
+	ownProcRoot, ownSysRoot = "testdata/proc", "testdata/sys"
+
+	first, err := limits(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := first[0].(measurements.LimitsMeasurement)
+	if !m.IsReference() {
+		t.Error("first measurement should only be a reference")
+	}
+
+	// the memory limit is set by the cgroup itself, the CPU limit of two CPUs by its parent
+	cpus := math.Min(2, float64(runtime.NumCPU()))
+	if m.Cgroup != "/docker/abc" || m.MemoryLimit != 1<<30 || m.MemoryCurrent != 1<<28 || m.Memoryp != 25 || m.CPULimit != cpus {
+		t.Errorf("unexpected limits %+v", m)
+	}
+
+	// pretend the first reading was a second ago and the cgroup used one CPU since
+	m.Source.Time = m.Source.Time.Add(-time.Second)
+	m.Source.Usage -= 1000000
+	m.Source.Periods -= 10
+	m.Source.Throttled -= 2
+
+	next, err := limits([]measurements.Measurement{m}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := next[0].(measurements.LimitsMeasurement)
+	if n.IsReference() || n.Periods != 10 || n.Throttled != 2 {
+		t.Errorf("expected 2 of 10 periods throttled, got %+v", n)
+	}
+	if math.Abs(n.CPUUsage-1) > 0.01 || math.Abs(n.Cpup-100/cpus) > 1 {
+		t.Errorf("expected one CPU used, got %.2f CPUs, %.2f%%", n.CPUUsage, n.Cpup)
+	}
+}
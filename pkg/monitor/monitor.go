@@ -3,48 +3,106 @@ package monitor
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"math"
 	"time"
 
-	cpustat "github.com/mackerelio/go-osstat/cpu"
-	memstat "github.com/mackerelio/go-osstat/memory"
-	netstat "github.com/mackerelio/go-osstat/network"
-
 	"github.com/valentin-carl/stattrack/pkg/measurements"
 )
 
-func Monitor(ctx context.Context, ticker <-chan time.Time, out chan<- measurements.Measurement, mT measurements.MeasurementType) error {
+// Monitor collects measurements of type `mT` on every tick and sends them to `out`.
+// Ticks are expected every `interval`, missed ticks and failed collections are sent to `gaps` (if it isn't nil).
+func Monitor(
+	ctx context.Context,
+	ticker <-chan time.Time,
+	out chan<- measurements.Measurement,
+	gaps chan<- measurements.Measurement,
+	mT measurements.MeasurementType,
+	interval time.Duration,
+) error {
 
 	var (
-		err  error
-		prev []measurements.Measurement
+		err      error
+		prev     []measurements.Measurement
+		lastTick time.Time
 	)
 
-	log.Printf("monitor of measurementType %d starting\n", mT)
+	logger := slog.With("monitor", mT)
+	logger.Info("monitor starting")
+
+	// the watchdog checks that the monitors keep handling ticks
+	beat(mT, time.Now())
+	defer stopBeating(mT)
+
+	sendGap := func(start, end time.Time, missed int64, reason string) {
+		if gaps == nil {
+			return
+		}
+		gap := measurements.Gap{
+			Timestamp: time.Now().Unix(),
+			Type:      mT,
+			Start:     start.UnixMilli(),
+			End:       end.UnixMilli(),
+			Missed:    missed,
+			Reason:    reason,
+		}
+		select {
+		case gaps <- gap:
+		case <-ctx.Done():
+		}
+	}
 
 	for {
 		select {
-		case <-ticker:
+		case tick := <-ticker:
 			{
 
-				log.Printf("monitor %d: getting measurement\n", mT)
+				logger.Debug("getting measurement")
 
-				curr, err := getMeasurements(prev, mT)
+				// the ticker drops ticks nobody is waiting for, e.g., if the system stalled or the last collection took too long
+				if !lastTick.IsZero() {
+					missed := int64(math.Round(float64(tick.Sub(lastTick))/float64(interval))) - 1
+					if missed > 0 {
+						logger.Warn("missed ticks, recording gap", "missed", missed, "since", lastTick)
+						sendGap(lastTick, tick, missed, "missed ticks")
+					}
+				}
+				lastTick = tick
+
+				curr, err := collect(prev, mT, tick)
+				beat(mT, time.Now())
 				if err != nil {
-					log.Panicf("go-osstats wasn't able to retrieve os measurements of type %d\n", mT)
+					// a failed collection is a gap in the data, the next tick tries again
+					// `prev` is kept so relative values can still be computed afterwards
+					logger.Error("could not collect measurements, recording gap", "errors", countError(mT), "err", err)
+					sendGap(tick, tick.Add(interval), 1, err.Error())
+					continue
+				}
+
+				if latency := time.Since(tick); latency > interval/2 {
+					logger.Debug("late measurement", "latency", latency)
 				}
 
 				// send all current measurements
 				// it's a slice because there could be multiple network interfaces
 				for _, mm := range curr {
-					log.Printf("monitor %d: sending message\n", mT)
+
+					// measurements without relative values are only kept as `prev`
+					if ref, ok := mm.(measurements.Reference); ok && ref.IsReference() {
+						continue
+					}
+
+					logger.Debug("sending measurement")
 
 					// FIXME see issue #2
 					mm := mm
 					go func() {
-						// TODO problem: if the backend crashes, this creates a lot of goroutines that try to send something through the channel!!!
-						out <- mm
+						// the backend stops reading once the context is cancelled, e.g., by a reload removing the type
+						select {
+						case out <- mm:
+						case <-ctx.Done():
+						}
 					}()
 				}
 
@@ -52,53 +110,101 @@ func Monitor(ctx context.Context, ticker <-chan time.Time, out chan<- measuremen
 			}
 		case <-ctx.Done():
 			{
-				log.Printf("monitor %d: context was cancelled\n", mT)
+				logger.Debug("context was cancelled")
 				goto TheEnd
 			}
 		}
 	}
 
 TheEnd:
-	log.Printf("monitor %d is done\n", mT)
+	logger.Info("monitor done")
 
 	return err
 }
 
-func getMeasurements(previous []measurements.Measurement, mT measurements.MeasurementType) ([]measurements.Measurement, error) {
+// collect gets the measurements and turns a panicking collector into an error,
+// so a single broken collector doesn't take down the other monitors and the backends
+func collect(previous []measurements.Measurement, mT measurements.MeasurementType, tick time.Time) (res []measurements.Measurement, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("collector panicked: %v", r)
+		}
+	}()
+
+	return getMeasurements(previous, mT, tick)
+}
+
+// getMeasurements collects the current measurements of a type.
+// `tick` is when the measurements were due, the collectors store their latency relative to it.
+func getMeasurements(previous []measurements.Measurement, mT measurements.MeasurementType, tick time.Time) ([]measurements.Measurement, error) {
 
 	switch mT {
 	case measurements.CPU:
 		{
-			return cpu(previous)
+			return cpu(previous, tick)
 		}
 	case measurements.MEM:
 		{
-			return mem(previous)
+			return mem(previous, tick)
 		}
 	case measurements.NET:
 		{
-			return net(previous)
+			return net(previous, tick)
+		}
+	case measurements.PROTO:
+		{
+			return protocols(previous, tick)
+		}
+	case measurements.LOAD:
+		{
+			return load(previous, tick)
+		}
+	case measurements.PSI:
+		{
+			return pressure(previous, tick)
+		}
+	case measurements.FS:
+		{
+			return filesystems(previous, tick)
+		}
+	case measurements.CGROUP:
+		{
+			return cgroups(previous, tick)
+		}
+	case measurements.LIMITS:
+		{
+			return limits(previous, tick)
+		}
+	case measurements.MEMX:
+		{
+			return extendedMemory(previous, tick)
+		}
+	case measurements.SENSORS:
+		{
+			return sensors(previous, tick)
 		}
 	}
 
-	log.Panicln("invalid measurement type")
-	return nil, errors.New("invalid measurement type")
+	return nil, measurements.ErrUnknownType
 }
 
-func cpu(previous []measurements.Measurement) ([]measurements.Measurement, error) {
+func cpu(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
 
 	if previous == nil || len(previous) == 0 {
 
-		log.Println("no previous measurements, cannot compute relative values")
+		slog.Debug("no previous CPU measurements, cannot compute relative values")
 
-		curr, err := cpustat.Get()
+		curr, err := readCPUTimes(procPath(procStat))
 		if err != nil {
 			return nil, err
 		}
 
+		now := time.Now()
+
 		// return without relative values to be able to calculate them in the next iteration
 		return []measurements.Measurement{measurements.CPUMeasurement{
-			Timestamp: time.Now().Unix(),
+			Timestamp: now.Unix(),
 			User:      curr.User,
 			System:    curr.System,
 			Idle:      curr.Idle,
@@ -107,6 +213,7 @@ func cpu(previous []measurements.Measurement) ([]measurements.Measurement, error
 			Userp:     math.NaN(),
 			Systp:     math.NaN(),
 			Idlep:     math.NaN(),
+			Latency:   now.Sub(tick).Microseconds(),
 		}}, nil
 	}
 
@@ -114,16 +221,15 @@ func cpu(previous []measurements.Measurement) ([]measurements.Measurement, error
 
 	prev, ok := prev_cpu.(measurements.CPUMeasurement)
 	if !ok {
-		log.Panicln("type assertion failed: tried measurement.Measurement -> measurement.CPUMeasurement")
+		return nil, errors.New("type assertion failed: tried measurement.Measurement -> measurement.CPUMeasurement")
 	}
 
 	var result measurements.CPUMeasurement
 
-	timestamp := time.Now().Unix()
+	now := time.Now()
 
-	curr, err := cpustat.Get()
+	curr, err := readCPUTimes(procPath(procStat))
 	if err != nil {
-		log.Println("Error:", err.Error())
 		return []measurements.Measurement{result}, err
 	}
 
@@ -133,7 +239,7 @@ func cpu(previous []measurements.Measurement) ([]measurements.Measurement, error
 	idlep := (float64(curr.Idle-prev.Idle) / tDiff) * 100
 
 	result = measurements.CPUMeasurement{
-		Timestamp: timestamp,
+		Timestamp: now.Unix(),
 		User:      curr.User,
 		System:    curr.System,
 		Idle:      curr.Idle,
@@ -142,42 +248,54 @@ func cpu(previous []measurements.Measurement) ([]measurements.Measurement, error
 		Userp:     userp,
 		Systp:     systp,
 		Idlep:     idlep,
+		Latency:   now.Sub(tick).Microseconds(),
 	}
 
 	return []measurements.Measurement{result}, nil
 }
 
-func mem(previous []measurements.Measurement) ([]measurements.Measurement, error) {
+func mem(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
 
 	// `previous` is not required to calculate memory stats
 
-	timestamp := time.Now().Unix()
+	now := time.Now()
 
-	curr, err := memstat.Get()
+	curr, err := readMeminfo(procPath(procMeminfo))
 	if err != nil {
-		log.Println("Error:", err.Error())
 		var result measurements.MemoryMeasurement
 		return []measurements.Measurement{result}, err
 	}
 
-	freep := float64(curr.Free) / float64(curr.Total) * 100
+	total, free := curr["MemTotal"], curr["MemFree"]
+	if total == 0 {
+		return nil, fmt.Errorf("%s: no MemTotal", procPath(procMeminfo))
+	}
+
+	// without MemAvailable (before Linux 3.14), buffers and page cache count as free
+	used := total - free - curr["Buffers"] - curr["Cached"]
+	if available, ok := curr["MemAvailable"]; ok {
+		used = total - available
+	}
+
+	freep := float64(free) / float64(total) * 100
 
 	return []measurements.Measurement{measurements.MemoryMeasurement{
-		Timestamp: timestamp,
-		Free:      curr.Free,
-		Total:     curr.Total,
-		Active:    curr.Active,
-		Cached:    curr.Cached,
-		Inactive:  curr.Inactive,
-		SwapFree:  curr.SwapFree,
-		SwapUsed:  curr.SwapUsed,
-		SwapTotal: curr.SwapTotal,
-		Used:      curr.Used,
+		Timestamp: now.Unix(),
+		Free:      free,
+		Total:     total,
+		Active:    curr["Active"],
+		Cached:    curr["Cached"],
+		Inactive:  curr["Inactive"],
+		SwapFree:  curr["SwapFree"],
+		SwapUsed:  curr["SwapTotal"] - curr["SwapFree"],
+		SwapTotal: curr["SwapTotal"],
+		Used:      used,
 		Freep:     freep,
+		Latency:   now.Sub(tick).Microseconds(),
 	}}, nil
 }
 
-func net(previous []measurements.Measurement) ([]measurements.Measurement, error) {
+func net(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
 
 	// helper
 	toMap := func(mms []measurements.Measurement) map[string]measurements.NetworkMeasurement {
@@ -185,7 +303,7 @@ func net(previous []measurements.Measurement) ([]measurements.Measurement, error
 		for _, m := range mms {
 			current, ok := m.(measurements.NetworkMeasurement)
 			if !ok {
-				log.Panicln("invalid measurement type")
+				continue
 			}
 			res[current.Source.Name] = current
 		}
@@ -194,42 +312,61 @@ func net(previous []measurements.Measurement) ([]measurements.Measurement, error
 
 	prev := toMap(previous)
 
-	current, err := netstat.Get()
+	current, err := readNetDev(procPath(procNetDev))
 	if err != nil {
-		log.Println("something went wrong while trying to retrieve network stats")
 		return []measurements.Measurement{}, err
 	}
 
-	result := make([]measurements.Measurement, len(current))
+	now := time.Now()
+	result := make([]measurements.Measurement, 0, len(current))
 
-	for i, curr := range current {
+	for _, curr := range current {
 
-		var m measurements.NetworkMeasurement
+		if !config.Interfaces.Match(curr.Name) {
+			continue
+		}
+
+		m := measurements.NetworkMeasurement{
+			Timestamp: now.Unix(),
+			Interface: curr.Name,
+			Source:    curr,
+			Latency:   now.Sub(tick).Microseconds(),
+		}
 
+		// without previous counters there is nothing to compute, the first measurement
+		// of an interface is only kept to compute the next one
 		prevm, ok := prev[curr.Name]
-		if ok {
-			//            log.Printf("found previous value for interface %s\n", curr.Name)
-			m = measurements.NetworkMeasurement{
-				Timestamp: time.Now().Unix(),
-				Interface: curr.Name,
-				RxBytes:   curr.RxBytes - prevm.RxBytes,
-				TxBytes:   curr.TxBytes - prevm.TxBytes,
-				Source:    curr,
-			}
-		} else {
-			log.Printf("didn't find previous value for interface %s\n", m.Interface)
-			// TODO check if using absolute values here creates weird data
-			//  => possible fix: don't store the first iteration of network measurements
-			m = measurements.NetworkMeasurement{
-				Timestamp: time.Now().Unix(),
-				Interface: curr.Name,
-				RxBytes:   curr.RxBytes,
-				TxBytes:   curr.TxBytes,
-				Source:    curr,
+		if !ok {
+			slog.Debug("no previous network measurement", "interface", curr.Name)
+			m.First = true
+			result = append(result, m)
+			continue
+		}
+
+		delta, ok := counterDeltas(prevm.Source.InterfaceCounters, curr.InterfaceCounters)
+		if !ok {
+			slog.Warn("network counters were reset, skipping measurement", "interface", curr.Name)
+			m.First = true
+			result = append(result, m)
+			continue
+		}
+
+		// the ticker isn't exact and ticks can be missed, so the rates use the actual time between the readings
+		elapsed := curr.Time.Sub(prevm.Source.Time).Seconds()
+		rate := func(delta uint64) float64 {
+			if elapsed <= 0 {
+				return 0
 			}
+			return float64(delta) / elapsed
 		}
 
-		result[i] = m
+		m.InterfaceCounters = delta
+		m.RxBytesPerSec = rate(delta.RxBytes)
+		m.TxBytesPerSec = rate(delta.TxBytes)
+		m.RxPacketsPerSec = rate(delta.RxPackets)
+		m.TxPacketsPerSec = rate(delta.TxPackets)
+
+		result = append(result, m)
 	}
 
 	return result, nil
@@ -0,0 +1,229 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// useFixtures points the collectors at a fake procfs and sysfs in testdata until the test is done
+func useFixtures(t *testing.T, procRoot, sysRoot string) {
+	t.Helper()
+	previous := config
+	t.Cleanup(func() { config = previous })
+	c := DefaultConfig()
+	c.ProcRoot, c.SysRoot = procRoot, sysRoot
+	Configure(c)
+}
+
+func TestCPU(t *testing.T) {
+
+	useFixtures(t, "testdata/proc", "testdata/sys")
+
+	first, err := cpu(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 {
+		t.Fatalf("expected 1 measurement, got %d", len(first))
+	}
+
+	m := first[0].(measurements.CPUMeasurement)
+	if m.User != 1000 || m.Nice != 100 || m.System != 500 || m.Idle != 8000 {
+		t.Errorf("unexpected raw values: %+v", m)
+	}
+	// guest and guest_nice are already part of user and nice
+	if m.Total != 9850 {
+		t.Errorf("expected total 9850, got %d", m.Total)
+	}
+	if !math.IsNaN(m.Userp) || !math.IsNaN(m.Systp) || !math.IsNaN(m.Idlep) {
+		t.Errorf("expected no percentages without a previous measurement, got %+v", m)
+	}
+	// the first measurement is only kept to compute the next one
+	if !m.IsReference() {
+		t.Errorf("expected the first measurement to be a reference")
+	}
+
+	config.ProcRoot = "testdata/proc-next"
+
+	next, err := cpu(first, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = next[0].(measurements.CPUMeasurement)
+	if m.IsReference() {
+		t.Errorf("expected the second measurement to be recorded")
+	}
+	for name, got := range map[string][2]float64{
+		"userp":   {m.Userp, 30},
+		"systemp": {m.Systp, 15},
+		"idlep":   {m.Idlep, 50},
+	} {
+		if math.Abs(got[0]-got[1]) > 1e-9 {
+			t.Errorf("expected %s %.2f, got %.2f", name, got[1], got[0])
+		}
+	}
+}
+
+func TestMem(t *testing.T) {
+
+	useFixtures(t, "testdata/proc", "testdata/sys")
+
+	result, err := mem(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := result[0].(measurements.MemoryMeasurement)
+	expected := measurements.MemoryMeasurement{
+		Free:      2000000 * 1024,
+		Total:     8000000 * 1024,
+		Active:    3000000 * 1024,
+		Cached:    2500000 * 1024,
+		Inactive:  1500000 * 1024,
+		SwapFree:  750000 * 1024,
+		SwapTotal: 1000000 * 1024,
+		SwapUsed:  250000 * 1024,
+		Used:      3000000 * 1024, // total - available
+		Freep:     25,
+	}
+	expected.Timestamp, expected.Latency = m.Timestamp, m.Latency
+	if m != expected {
+		t.Errorf("expected %+v, got %+v", expected, m)
+	}
+}
+
+func TestNet(t *testing.T) {
+
+	useFixtures(t, "testdata/proc", "testdata/sys")
+
+	first, err := net(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lo is excluded by default, the others have no previous counters yet
+	if len(first) != 3 {
+		t.Fatalf("expected 3 interfaces, got %d", len(first))
+	}
+	for _, mm := range first {
+		m := mm.(measurements.NetworkMeasurement)
+		if m.Interface == "lo" {
+			t.Error("lo should be excluded")
+		}
+		if !m.IsReference() {
+			t.Errorf("first measurement of %s should only be a reference", m.Interface)
+		}
+	}
+
+	// pretend the first reading was two seconds ago
+	for i, mm := range first {
+		m := mm.(measurements.NetworkMeasurement)
+		m.Source.Time = m.Source.Time.Add(-2 * time.Second)
+		first[i] = m
+	}
+
+	config.ProcRoot = "testdata/proc-next"
+
+	next, err := net(first, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]measurements.NetworkMeasurement)
+	for _, mm := range next {
+		m := mm.(measurements.NetworkMeasurement)
+		byName[m.Interface] = m
+	}
+
+	eth0 := byName["eth0"]
+	expected := measurements.InterfaceCounters{
+		RxBytes:   500000,
+		TxBytes:   200000,
+		RxPackets: 500,
+		TxPackets: 200,
+		RxErrors:  2,
+		TxDropped: 3,
+		RxFifo:    1,
+		Multicast: 2,
+	}
+	if eth0.IsReference() || eth0.InterfaceCounters != expected {
+		t.Errorf("expected eth0 deltas %+v, got %+v", expected, eth0.InterfaceCounters)
+	}
+	if math.Abs(eth0.RxBytesPerSec-250000) > 2500 || math.Abs(eth0.TxPacketsPerSec-100) > 1 {
+		t.Errorf("expected rates over two seconds, got %.2f B/s rx and %.2f packets/s tx", eth0.RxBytesPerSec, eth0.TxPacketsPerSec)
+	}
+
+	// the receive counter of wlan0 wrapped around at 32 bits
+	if wlan0 := byName["wlan0"]; wlan0.IsReference() || wlan0.RxBytes != 1000 || wlan0.TxBytes != 100 {
+		t.Errorf("expected wlan0 to wrap around to 1000 bytes received, got %+v", wlan0.InterfaceCounters)
+	}
+
+	// the counters of docker0 were reset, it starts over
+	if docker0 := byName["docker0"]; !docker0.IsReference() {
+		t.Errorf("expected docker0 to be a reference after a reset, got %+v", docker0.InterfaceCounters)
+	}
+}
+
+func TestNetFilter(t *testing.T) {
+
+	useFixtures(t, "testdata/proc", "testdata/sys")
+	config.Interfaces = Filter{Include: Patterns{"*0"}, Exclude: Patterns{"docker*"}}
+
+	result, err := net(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, mm := range result {
+		names = append(names, mm.(measurements.NetworkMeasurement).Interface)
+	}
+	if len(names) != 2 || names[0] != "eth0" || names[1] != "wlan0" {
+		t.Errorf("expected eth0 and wlan0, got %v", names)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+
+	for _, test := range []struct {
+		prev, curr, delta uint64
+		ok                bool
+	}{
+		{10, 15, 5, true},
+		{10, 10, 0, true},
+		{math.MaxUint32 - 4, 5, 10, true}, // 32-bit wrap
+		{math.MaxUint64 - 4, 5, 0, false}, // 64-bit counters don't wrap in practice
+		{1000, 10, 0, false},              // reset
+	} {
+		delta, ok := counterDelta(test.prev, test.curr)
+		if delta != test.delta || ok != test.ok {
+			t.Errorf("counterDelta(%d, %d) = %d, %t, expected %d, %t", test.prev, test.curr, delta, ok, test.delta, test.ok)
+		}
+	}
+}
+
+func TestStalled(t *testing.T) {
+
+	t.Cleanup(func() {
+		stopBeating(measurements.CPU)
+		stopBeating(measurements.MEM)
+	})
+
+	beat(measurements.CPU, time.Now())
+	beat(measurements.MEM, time.Now().Add(-time.Minute))
+
+	stalled := Stalled(10 * time.Second)
+	if len(stalled) != 1 || stalled[0] != measurements.MEM {
+		t.Errorf("expected only the memory monitor to be stalled, got %v", stalled)
+	}
+
+	// a monitor that is done doesn't count
+	stopBeating(measurements.MEM)
+	if stalled := Stalled(10 * time.Second); len(stalled) != 0 {
+		t.Errorf("expected no stalled monitors, got %v", stalled)
+	}
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// files in procfs
+const (
+	procLoadavg = "loadavg"
+	procStat    = "stat"
+)
+
+// readLoadavg reads the 1, 5 and 15 minute load averages from /proc/loadavg, which looks like
+//
+//	0.27 0.20 0.17 2/72 12536
+func readLoadavg(path string) (load [3]float64, err error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return load, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("%s: unexpected content %q", path, content)
+	}
+
+	for i := range load {
+		load[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return load, fmt.Errorf("%s: invalid load average: %w", path, err)
+		}
+	}
+
+	return load, nil
+}
+
+// readSchedulerStats reads the context switch, interrupt and fork counters
+// and the number of running and blocked processes from /proc/stat
+func readSchedulerStats(path string) (counters measurements.SchedulerCounters, running, blocked uint64, err error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return counters, 0, 0, err
+	}
+	defer file.Close()
+
+	counters.Time = time.Now()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20) // the intr line has one value per interrupt and can get long
+	for scanner.Scan() {
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var target *uint64
+		switch fields[0] {
+		case "ctxt":
+			target = &counters.ContextSwitches
+		case "intr":
+			target = &counters.Interrupts // the first value is the total
+		case "processes":
+			target = &counters.Forks
+		case "procs_running":
+			target = &running
+		case "procs_blocked":
+			target = &blocked
+		default:
+			continue
+		}
+
+		*target, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return counters, 0, 0, fmt.Errorf("%s: invalid value for %s: %w", path, fields[0], err)
+		}
+	}
+
+	return counters, running, blocked, scanner.Err()
+}
+
+// cpuTimes are the times all CPUs together spent in each state, in USER_HZ (usually 1/100 s)
+type cpuTimes struct {
+	User, Nice, System, Idle, Total uint64
+}
+
+// readCPUTimes reads the first line of /proc/stat, which looks like
+//
+//	cpu  user nice system idle iowait irq softirq steal guest guest_nice
+func readCPUTimes(path string) (times cpuTimes, err error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return times, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return times, err
+		}
+		return times, fmt.Errorf("%s: empty", path)
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return times, fmt.Errorf("%s: unexpected first line %q", path, scanner.Text())
+	}
+
+	values := make([]uint64, len(fields)-1)
+	for i := range values {
+		values[i], err = strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			return times, fmt.Errorf("%s: invalid CPU time: %w", path, err)
+		}
+		times.Total += values[i]
+	}
+
+	times.User, times.Nice, times.System, times.Idle = values[0], values[1], values[2], values[3]
+
+	// user and nice already include guest and guest_nice
+	if len(values) > 9 {
+		times.Total -= values[8] + values[9]
+	} else if len(values) > 8 {
+		times.Total -= values[8]
+	}
+
+	return times, nil
+}
+
+func load(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	averages, err := readLoadavg(procPath(procLoadavg))
+	if err != nil {
+		return nil, err
+	}
+
+	counters, running, blocked, err := readSchedulerStats(procPath(procStat))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m := measurements.LoadMeasurement{
+		Timestamp: now.Unix(),
+		Load1:     averages[0],
+		Load5:     averages[1],
+		Load15:    averages[2],
+		Running:   running,
+		Blocked:   blocked,
+		Source:    counters,
+		Latency:   now.Sub(tick).Microseconds(),
+	}
+
+	// the rates need the previous counters
+	var prev measurements.LoadMeasurement
+	ok := len(previous) > 0
+	if ok {
+		prev, ok = previous[0].(measurements.LoadMeasurement)
+	}
+	if !ok {
+		slog.Debug("no previous load measurement, cannot compute relative values")
+		m.First = true
+		return []measurements.Measurement{m}, nil
+	}
+
+	elapsed := counters.Time.Sub(prev.Source.Time).Seconds()
+	rate := func(prev, curr uint64) float64 {
+		d, valid := counterDelta(prev, curr)
+		ok = ok && valid
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(d) / elapsed
+	}
+
+	m.ContextSwitchesPerSec = rate(prev.Source.ContextSwitches, counters.ContextSwitches)
+	m.InterruptsPerSec = rate(prev.Source.Interrupts, counters.Interrupts)
+	m.ForksPerSec = rate(prev.Source.Forks, counters.Forks)
+	if !ok {
+		slog.Warn("scheduler counters were reset, skipping measurement")
+		m.First = true
+	}
+
+	return []measurements.Measurement{m}, nil
+}
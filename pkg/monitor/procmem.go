@@ -0,0 +1,171 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// files in procfs
+const (
+	procMeminfo = "meminfo"
+	procVmstat  = "vmstat"
+)
+
+// readMeminfo reads /proc/meminfo, whose lines look like
+//
+//	MemAvailable:    5637252 kB
+//	HugePages_Total:       0
+//
+// Values in kB are converted to bytes, the others are counts.
+func readMeminfo(path string) (map[string]uint64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %w", path, key, err)
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			value *= 1024
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// readVMCounters reads the page fault, reclaim and swap counters from /proc/vmstat
+func readVMCounters(path string) (counters measurements.VMCounters, err error) {
+
+	vmstat, err := readKeyValues(path)
+	if err != nil {
+		return counters, err
+	}
+	if len(vmstat) == 0 {
+		return counters, fmt.Errorf("%s: no counters", path)
+	}
+
+	// newer kernels split the scanned and stolen pages into anon and file,
+	// older ones only by who reclaimed them (kswapd, direct reclaim, ...)
+	sum := func(prefix string) uint64 {
+		if anon, ok := vmstat[prefix+"_anon"]; ok {
+			return anon + vmstat[prefix+"_file"]
+		}
+		var total uint64
+		for key, value := range vmstat {
+			if strings.HasPrefix(key, prefix+"_") && key != "pgscan_direct_throttle" {
+				total += value
+			}
+		}
+		return total
+	}
+
+	return measurements.VMCounters{
+		Faults:      vmstat["pgfault"],
+		MajorFaults: vmstat["pgmajfault"],
+		Scanned:     sum("pgscan"),
+		Stolen:      sum("pgsteal"),
+		SwapIn:      vmstat["pswpin"],
+		SwapOut:     vmstat["pswpout"],
+		Time:        time.Now(),
+	}, nil
+}
+
+func extendedMemory(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	meminfo, err := readMeminfo(procPath(procMeminfo))
+	if err != nil {
+		return nil, err
+	}
+
+	counters, err := readVMCounters(procPath(procVmstat))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m := measurements.ExtendedMemoryMeasurement{
+		Timestamp:      now.Unix(),
+		Available:      meminfo["MemAvailable"],
+		Buffers:        meminfo["Buffers"],
+		Cached:         meminfo["Cached"],
+		Dirty:          meminfo["Dirty"],
+		Writeback:      meminfo["Writeback"],
+		Slab:           meminfo["Slab"],
+		SReclaimable:   meminfo["SReclaimable"],
+		Shmem:          meminfo["Shmem"],
+		AnonHugePages:  meminfo["AnonHugePages"],
+		HugePagesTotal: meminfo["HugePages_Total"],
+		HugePagesFree:  meminfo["HugePages_Free"],
+		HugePageSize:   meminfo["Hugepagesize"],
+		Source:         counters,
+		Latency:        now.Sub(tick).Microseconds(),
+	}
+
+	// the page faults, reclaim and swap need the previous counters
+	var prev measurements.ExtendedMemoryMeasurement
+	ok := len(previous) > 0
+	if ok {
+		prev, ok = previous[0].(measurements.ExtendedMemoryMeasurement)
+	}
+	if !ok {
+		slog.Debug("no previous extended memory measurement, cannot compute relative values")
+		m.First = true
+		return []measurements.Measurement{m}, nil
+	}
+
+	sub := func(prev, curr uint64) uint64 {
+		d, valid := counterDelta(prev, curr)
+		ok = ok && valid
+		return d
+	}
+
+	faults := sub(prev.Source.Faults, counters.Faults)
+	m.MajorFaults = sub(prev.Source.MajorFaults, counters.MajorFaults)
+	if faults > m.MajorFaults {
+		m.MinorFaults = faults - m.MajorFaults
+	}
+	m.Scanned = sub(prev.Source.Scanned, counters.Scanned)
+	m.Stolen = sub(prev.Source.Stolen, counters.Stolen)
+	swapIn := sub(prev.Source.SwapIn, counters.SwapIn)
+	swapOut := sub(prev.Source.SwapOut, counters.SwapOut)
+	if !ok {
+		slog.Warn("vmstat counters were reset, skipping measurement")
+		m.First = true
+	}
+
+	if elapsed := counters.Time.Sub(prev.Source.Time).Seconds(); elapsed > 0 {
+		m.SwapInPerSec = float64(swapIn) / elapsed
+		m.SwapOutPerSec = float64(swapOut) / elapsed
+	}
+
+	return []measurements.Measurement{m}, nil
+}
@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// file in procfs
+const procNetDev = "net/dev"
+
+// readNetDev reads the counters of every network interface
+func readNetDev(path string) ([]measurements.InterfaceStats, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	now := time.Now()
+
+	var stats []measurements.InterfaceStats
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		// the first two lines are headers, the interface lines look like
+		//   eth0: 1234 12 0 0 0 0 0 0 5678 34 0 0 0 0 0 0
+		// with the receive counters bytes, packets, errs, drop, fifo, frame, compressed, multicast
+		// and the transmit counters bytes, packets, errs, drop, fifo, colls, carrier, compressed
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("%s: unexpected number of fields for interface %s: %d", path, name, len(fields))
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			values[i], err = strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid counter for interface %s: %w", path, name, err)
+			}
+		}
+
+		stats = append(stats, measurements.InterfaceStats{
+			Name: name,
+			InterfaceCounters: measurements.InterfaceCounters{
+				RxBytes:   values[0],
+				RxPackets: values[1],
+				RxErrors:  values[2],
+				RxDropped: values[3],
+				RxFifo:    values[4],
+				Multicast: values[7],
+				TxBytes:   values[8],
+				TxPackets: values[9],
+				TxErrors:  values[10],
+				TxDropped: values[11],
+				TxFifo:    values[12],
+			},
+			Time: now,
+		})
+	}
+
+	return stats, scanner.Err()
+}
+
+// counterDelta returns how much a counter increased since `prev`.
+// Some drivers still use 32-bit counters, which wrap around at math.MaxUint32. A counter that
+// decreased from the upper half of that range is assumed to have wrapped, anything else is a reset
+// (the interface was re-created, the driver reloaded, ...), which `ok` reports as false.
+func counterDelta(prev, curr uint64) (delta uint64, ok bool) {
+
+	if curr >= prev {
+		return curr - prev, true
+	}
+
+	if prev <= math.MaxUint32 && prev > math.MaxUint32/2 && curr <= math.MaxUint32 {
+		return math.MaxUint32 - prev + curr + 1, true
+	}
+
+	return 0, false
+}
+
+// counterDeltas returns the increase of every counter, `ok` is false if any of them was reset
+func counterDeltas(prev, curr measurements.InterfaceCounters) (delta measurements.InterfaceCounters, ok bool) {
+
+	ok = true
+	sub := func(prev, curr uint64) uint64 {
+		d, valid := counterDelta(prev, curr)
+		ok = ok && valid
+		return d
+	}
+
+	delta = measurements.InterfaceCounters{
+		RxBytes:   sub(prev.RxBytes, curr.RxBytes),
+		TxBytes:   sub(prev.TxBytes, curr.TxBytes),
+		RxPackets: sub(prev.RxPackets, curr.RxPackets),
+		TxPackets: sub(prev.TxPackets, curr.TxPackets),
+		RxErrors:  sub(prev.RxErrors, curr.RxErrors),
+		TxErrors:  sub(prev.TxErrors, curr.TxErrors),
+		RxDropped: sub(prev.RxDropped, curr.RxDropped),
+		TxDropped: sub(prev.TxDropped, curr.TxDropped),
+		RxFifo:    sub(prev.RxFifo, curr.RxFifo),
+		TxFifo:    sub(prev.TxFifo, curr.TxFifo),
+		Multicast: sub(prev.Multicast, curr.Multicast),
+	}
+
+	return delta, ok
+}
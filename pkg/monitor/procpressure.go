@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// directory in procfs
+const procPressure = "pressure"
+
+// resources with pressure stall information
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// readPressure reads a file from /proc/pressure, which looks like
+//
+//	some avg10=2.34 avg60=1.64 avg300=1.57 total=34797688
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// The measurement's averages and Source are set, the stall times are left for the caller.
+func readPressure(file string) (m measurements.PressureMeasurement, err error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	m.Resource = path.Base(file)
+	m.Source.Resource = m.Resource
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var avg10, avg60 *float64
+		var total *uint64
+		switch fields[0] {
+		case "some":
+			avg10, avg60, total = &m.SomeAvg10, &m.SomeAvg60, &m.Source.SomeTotal
+		case "full":
+			avg10, avg60, total = &m.FullAvg10, &m.FullAvg60, &m.Source.FullTotal
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				*avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				*avg60, err = strconv.ParseFloat(value, 64)
+			case "total":
+				*total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return m, fmt.Errorf("%s: invalid value for %s %s: %w", file, fields[0], key, err)
+			}
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+func pressure(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	prev := make(map[string]measurements.PressureMeasurement)
+	for _, m := range previous {
+		if p, ok := m.(measurements.PressureMeasurement); ok {
+			prev[p.Resource] = p
+		}
+	}
+
+	var result []measurements.Measurement
+
+	for _, resource := range pressureResources {
+
+		// with psi=0 the files exist, but reading them fails with EOPNOTSUPP
+		m, err := readPressure(procPath(procPressure, resource))
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EOPNOTSUPP) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		m.Timestamp = now.Unix()
+		m.Latency = now.Sub(tick).Microseconds()
+
+		// the stall times are the increase of the totals
+		prevm, ok := prev[resource]
+		if !ok {
+			slog.Debug("no previous pressure measurement", "resource", resource)
+			m.First = true
+			result = append(result, m)
+			continue
+		}
+
+		var someOk, fullOk bool
+		m.SomeStall, someOk = counterDelta(prevm.Source.SomeTotal, m.Source.SomeTotal)
+		m.FullStall, fullOk = counterDelta(prevm.Source.FullTotal, m.Source.FullTotal)
+		if !someOk || !fullOk {
+			slog.Warn("pressure totals were reset, skipping measurement", "resource", resource)
+			m.First = true
+		}
+
+		result = append(result, m)
+	}
+
+	// kernels without CONFIG_PSI don't have /proc/pressure, those booted with psi=0 can't read it
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no pressure stall information in %s", procPath(procPressure))
+	}
+
+	return result, nil
+}
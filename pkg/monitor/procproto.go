@@ -0,0 +1,189 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// files in procfs
+const (
+	procNetSnmp     = "net/snmp"
+	procNetNetstat  = "net/netstat"
+	procNetSockstat = "net/sockstat"
+)
+
+// readProtocolTable reads files like /proc/net/snmp and /proc/net/netstat, which consist of pairs of lines
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 15 ...
+//
+// and returns the values by protocol and name, e.g., values["Tcp"]["ActiveOpens"]
+func readProtocolTable(path string) (map[string]map[string]int64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]map[string]int64)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		protocol, header, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || !scanner.Scan() {
+			continue
+		}
+		_, line, _ := strings.Cut(scanner.Text(), ":")
+
+		names, fields := strings.Fields(header), strings.Fields(line)
+		if len(names) != len(fields) {
+			return nil, fmt.Errorf("%s: %d names but %d values for %s", path, len(names), len(fields), protocol)
+		}
+
+		values[protocol] = make(map[string]int64, len(names))
+		for i, name := range names {
+			values[protocol][name], err = strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s %s: %w", path, protocol, name, err)
+			}
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readSockstat reads /proc/net/sockstat, whose lines look like
+//
+//	TCP: inuse 8 orphan 0 tw 2 alloc 8 mem 223
+//
+// and returns the values by protocol and name, e.g., values["TCP"]["tw"]
+func readSockstat(path string) (map[string]map[string]int64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]map[string]int64)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		protocol, line, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		values[protocol] = make(map[string]int64, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			values[protocol][fields[i]], err = strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s %s: %w", path, protocol, fields[i], err)
+			}
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readProtocols reads the current TCP and UDP counters and the number of established and TIME_WAIT connections
+func readProtocols() (counters measurements.ProtocolCounters, established, timeWait uint64, err error) {
+
+	snmp, err := readProtocolTable(procPath(procNetSnmp))
+	if err != nil {
+		return counters, 0, 0, err
+	}
+
+	// /proc/net/netstat doesn't exist in every kernel configuration, the counters from it stay zero then
+	netstat, err := readProtocolTable(procPath(procNetNetstat))
+	if err != nil && !os.IsNotExist(err) {
+		return counters, 0, 0, err
+	}
+
+	sockstat, err := readSockstat(procPath(procNetSockstat))
+	if err != nil {
+		return counters, 0, 0, err
+	}
+
+	// missing protocols or names are nil maps, which read as zero
+	tcp, udp, tcpExt := snmp["Tcp"], snmp["Udp"], netstat["TcpExt"]
+
+	counters = measurements.ProtocolCounters{
+		ActiveOpens:     uint64(tcp["ActiveOpens"]),
+		PassiveOpens:    uint64(tcp["PassiveOpens"]),
+		AttemptFails:    uint64(tcp["AttemptFails"]),
+		EstabResets:     uint64(tcp["EstabResets"]),
+		OutRsts:         uint64(tcp["OutRsts"]),
+		RetransSegs:     uint64(tcp["RetransSegs"]),
+		ListenDrops:     uint64(tcpExt["ListenDrops"]),
+		UDPInErrors:     uint64(udp["InErrors"]),
+		UDPRcvbufErrors: uint64(udp["RcvbufErrors"]),
+	}
+
+	return counters, uint64(tcp["CurrEstab"]), uint64(sockstat["TCP"]["tw"]), nil
+}
+
+func protocols(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	counters, established, timeWait, err := readProtocols()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m := measurements.ProtocolMeasurement{
+		Timestamp:   now.Unix(),
+		Established: established,
+		TimeWait:    timeWait,
+		Source:      counters,
+		Latency:     now.Sub(tick).Microseconds(),
+	}
+
+	// the counters only tell something relative to the previous measurement
+	var prev measurements.ProtocolMeasurement
+	ok := len(previous) > 0
+	if ok {
+		prev, ok = previous[0].(measurements.ProtocolMeasurement)
+	}
+	if !ok {
+		slog.Debug("no previous protocol measurement, cannot compute relative values")
+		m.First = true
+		return []measurements.Measurement{m}, nil
+	}
+
+	sub := func(prev, curr uint64) uint64 {
+		d, valid := counterDelta(prev, curr)
+		ok = ok && valid
+		return d
+	}
+
+	m.ProtocolCounters = measurements.ProtocolCounters{
+		ActiveOpens:     sub(prev.Source.ActiveOpens, counters.ActiveOpens),
+		PassiveOpens:    sub(prev.Source.PassiveOpens, counters.PassiveOpens),
+		AttemptFails:    sub(prev.Source.AttemptFails, counters.AttemptFails),
+		EstabResets:     sub(prev.Source.EstabResets, counters.EstabResets),
+		OutRsts:         sub(prev.Source.OutRsts, counters.OutRsts),
+		RetransSegs:     sub(prev.Source.RetransSegs, counters.RetransSegs),
+		ListenDrops:     sub(prev.Source.ListenDrops, counters.ListenDrops),
+		UDPInErrors:     sub(prev.Source.UDPInErrors, counters.UDPInErrors),
+		UDPRcvbufErrors: sub(prev.Source.UDPRcvbufErrors, counters.UDPRcvbufErrors),
+	}
+	if !ok {
+		slog.Warn("protocol counters were reset, skipping measurement")
+		m.First = true
+	}
+
+	return []measurements.Measurement{m}, nil
+}
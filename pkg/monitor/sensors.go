@@ -0,0 +1,150 @@
+package monitor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// sensor is a sysfs file with a reading and how to turn it into the kind's unit
+type sensor struct {
+	name, kind string
+	file       string
+	scale      float64 // the reading is multiplied with it
+}
+
+// readSysfsString reads a sysfs attribute like a hwmon's name
+func readSysfsString(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// discoverSensors lists the hwmon temperatures and fans, the thermal zones and the CPU frequencies below `root`.
+// It runs every tick, sensors can appear and disappear, e.g., when a USB device is plugged in.
+func discoverSensors(root string) []sensor {
+
+	var sensors []sensor
+
+	// hwmon devices have a name and numbered inputs with optional labels, e.g.,
+	//   /sys/class/hwmon/hwmon3/name         coretemp
+	//   /sys/class/hwmon/hwmon3/temp1_label  Package id 0
+	//   /sys/class/hwmon/hwmon3/temp1_input  45000 (millidegrees)
+	hwmons, _ := filepath.Glob(path.Join(root, "class/hwmon/hwmon*"))
+	for _, hwmon := range hwmons {
+
+		name, err := readSysfsString(path.Join(hwmon, "name"))
+		if err != nil {
+			name = path.Base(hwmon)
+		}
+
+		for _, input := range []struct {
+			prefix, kind string
+			scale        float64
+		}{
+			{"temp", measurements.Temperature, 0.001},
+			{"fan", measurements.Fan, 1},
+		} {
+			files, _ := filepath.Glob(path.Join(hwmon, input.prefix+"*_input"))
+			for _, file := range files {
+				id := strings.TrimSuffix(path.Base(file), "_input")
+				label, err := readSysfsString(path.Join(hwmon, id+"_label"))
+				if err != nil || label == "" {
+					label = id
+				}
+				sensors = append(sensors, sensor{
+					name:  name + "/" + label,
+					kind:  input.kind,
+					file:  file,
+					scale: input.scale,
+				})
+			}
+		}
+	}
+
+	// thermal zones have a type and a temperature in millidegrees
+	zones, _ := filepath.Glob(path.Join(root, "class/thermal/thermal_zone*"))
+	for _, zone := range zones {
+		name, err := readSysfsString(path.Join(zone, "type"))
+		if err != nil {
+			name = path.Base(zone)
+		}
+		sensors = append(sensors, sensor{
+			name:  "thermal/" + path.Base(zone) + "/" + name,
+			kind:  measurements.Temperature,
+			file:  path.Join(zone, "temp"),
+			scale: 0.001,
+		})
+	}
+
+	// the current frequency of each CPU is in kHz
+	frequencies, _ := filepath.Glob(path.Join(root, "devices/system/cpu/cpu*/cpufreq/scaling_cur_freq"))
+	for _, file := range frequencies {
+		sensors = append(sensors, sensor{
+			name:  path.Base(path.Dir(path.Dir(file))),
+			kind:  measurements.Frequency,
+			file:  file,
+			scale: 0.001,
+		})
+	}
+
+	slices.SortFunc(sensors, func(a, b sensor) int {
+		return strings.Compare(a.file, b.file)
+	})
+
+	return sensors
+}
+
+func sensors(previous []measurements.Measurement, tick time.Time) ([]measurements.Measurement, error) {
+
+	// `previous` is not required to read sensors
+
+	discovered := discoverSensors(config.SysRoot)
+	if len(discovered) == 0 {
+		return nil, fmt.Errorf("no sensors found in %s", config.SysRoot)
+	}
+
+	var result []measurements.Measurement
+
+	for _, s := range discovered {
+
+		reading, err := readSysfsString(s.file)
+		if err != nil {
+			// some drivers return errors for sensors that are present but not connected
+			slog.Debug("could not read sensor", "sensor", s.name, "err", err)
+			continue
+		}
+
+		value, err := strconv.ParseFloat(reading, 64)
+		if err != nil {
+			slog.Debug("invalid sensor reading", "sensor", s.name, "reading", reading)
+			continue
+		}
+
+		now := time.Now()
+
+		result = append(result, measurements.SensorMeasurement{
+			Timestamp: now.Unix(),
+			Sensor:    s.name,
+			Kind:      s.kind,
+			Value:     value * s.scale,
+			Latency:   now.Sub(tick).Microseconds(),
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("none of the sensors in %s could be read", config.SysRoot)
+	}
+
+	return result, nil
+}
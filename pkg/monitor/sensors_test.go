@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+func TestSensors(t *testing.T) {
+
+	useFixtures(t, "testdata/proc", "testdata/sys")
+
+	result, err := sensors(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []measurements.SensorMeasurement{
+		{Sensor: "coretemp/Package id 0", Kind: measurements.Temperature, Value: 45},
+		{Sensor: "coretemp/temp2", Kind: measurements.Temperature, Value: 41.5},
+		{Sensor: "thinkpad/fan1", Kind: measurements.Fan, Value: 2100},
+		{Sensor: "thermal/thermal_zone0/x86_pkg_temp", Kind: measurements.Temperature, Value: 50},
+		{Sensor: "cpu0", Kind: measurements.Frequency, Value: 2400},
+		{Sensor: "cpu1", Kind: measurements.Frequency, Value: 800},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d sensors, got %d: %v", len(expected), len(result), result)
+	}
+	for i, mm := range result {
+		m := mm.(measurements.SensorMeasurement)
+		m.Timestamp, m.Latency = 0, 0
+		if m != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], m)
+		}
+	}
+}
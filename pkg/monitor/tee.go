@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"context"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// Tee forwards every measurement from `in` to `out` and, without blocking, to each of the taps.
+// A tap that can't keep up misses measurements instead of slowing down the backend behind `out`.
+func Tee(ctx context.Context, in <-chan measurements.Measurement, out chan<- measurements.Measurement, taps ...chan<- measurements.Measurement) {
+	for {
+		select {
+		case value := <-in:
+			{
+				for _, tap := range taps {
+					select {
+					case tap <- value:
+					default:
+					}
+				}
+
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			{
+				return
+			}
+		}
+	}
+}
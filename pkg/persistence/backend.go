@@ -3,3 +3,27 @@ package persistence
 type Backend interface {
 	Start() error
 }
+
+// Flusher is implemented by backends that can tell when the values they've received are written,
+// Flush returns once they are, e.g., after writing out buffered values.
+// It's safe to call while the backend is running.
+type Flusher interface {
+	Flush() error
+}
+
+// flushRequest asks a backend's goroutine to flush and receives the result,
+// so the flush happens after the values the backend has received so far
+type flushRequest chan error
+
+// requestFlush hands a flush to a backend's goroutine, nothing is left to flush once the backend is done
+func requestFlush(flushes chan<- flushRequest, done <-chan struct{}) error {
+
+	reply := make(flushRequest, 1)
+
+	select {
+	case flushes <- reply:
+		return <-reply
+	case <-done:
+		return nil
+	}
+}
@@ -3,22 +3,24 @@ package persistence
 import (
 	"context"
 	"encoding/csv"
-	_ "encoding/csv"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path"
-	"strings"
 
-	"github.com/fatih/color"
 	"github.com/valentin-carl/stattrack/pkg/measurements"
 )
 
 type CSVBackend struct {
-	ctx    context.Context
-	values <-chan measurements.Measurement
-	mType  measurements.MeasurementType
-	writer csv.Writer
+	ctx     context.Context
+	values  <-chan measurements.Measurement
+	mType   measurements.MeasurementType
+	columns []string
+	file    *os.File
+	header  bool // whether the column names still have to be written
+	writer  csv.Writer
+	flushes chan flushRequest
+	done    chan struct{}
 }
 
 func NewCSVBackend(
@@ -28,35 +30,51 @@ func NewCSVBackend(
 	mType measurements.MeasurementType,
 ) (*CSVBackend, error) {
 
-	log.Println("creating new CSV backend")
+	slog.Debug("creating new CSV backend", "type", mType)
+
+	fileName, err := measurements.GetFileName(mType)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := measurements.GetColumnNames(mType)
+	if err != nil {
+		return nil, err
+	}
 
 	c := &CSVBackend{
-		ctx:    ctx,
-		values: values,
-		mType:  mType,
+		ctx:     ctx,
+		values:  values,
+		mType:   mType,
+		columns: columns,
+		flushes: make(chan flushRequest),
+		done:    make(chan struct{}),
 	}
 
-	err := os.MkdirAll(outdir, fs.ModePerm)
+	err = os.MkdirAll(outdir, fs.ModePerm)
 	if err != nil {
-		log.Println("error occurred while trying to create output directory", err.Error())
+		slog.Error("could not create output directory", "dir", outdir, "err", err)
 		return nil, err
 	}
 
-	fpath := path.Join(outdir, measurements.GetFileName(mType))
-	file, err := os.Create(fpath)
+	// a type that was recorded before, e.g., until a reload removed it, is continued in the same file
+	fpath := path.Join(outdir, fileName)
+	file, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		log.Println("error occurred while trying to create output file")
+		slog.Error("could not create output file", "file", fpath, "err", err)
 		return nil, err
 	}
 
 	s, err := file.Stat()
 	if err != nil {
-		log.Println("error occurred while trying to create output file")
+		slog.Error("could not create output file", "file", fpath, "err", err)
 		return nil, err
 	} else {
-		log.Printf("output file %s created with mod %s\n", s.Name(), s.Mode().String())
+		slog.Debug("output file created", "file", s.Name(), "mode", s.Mode().String())
 	}
 
+	c.file = file
+	c.header = s.Size() == 0
 	c.writer = *csv.NewWriter(file)
 
 	return c, nil
@@ -64,17 +82,23 @@ func NewCSVBackend(
 
 func (c *CSVBackend) Start() error {
 
-	log.Printf("csv backend for %d starting\n", measurements.MeasurementType(c.mType))
+	logger := slog.With("backend", "csv", "type", c.mType)
+	logger.Info("backend starting")
 
 	var err error
 
+	defer close(c.done)
+	defer c.file.Close()
+
 	// write csv title
-	err = c.writer.Write(measurements.GetColumnNames(c.mType))
-	if err != nil {
-		log.Println("error while trying to write column names for type", c.mType)
-		return err
+	if c.header {
+		err = c.writer.Write(c.columns)
+		if err != nil {
+			logger.Error("could not write column names", "err", err)
+			return err
+		}
+		c.writer.Flush()
 	}
-	c.writer.Flush()
 
 	// read + store values
 	for {
@@ -91,23 +115,44 @@ func (c *CSVBackend) Start() error {
 
 				vals, err := value.Record()
 				if err != nil {
-					log.Println(color.RedString("error getting record from measurement:", err.Error()))
+					logger.Error("could not get record from measurement", "err", err)
+					continue
 				}
 
-				log.Println("CSV backend: received value ", strings.Join(vals, ", "))
+				logger.Debug("received value", "values", vals)
 				c.writer.Write(vals)
 			}
+		case reply := <-c.flushes:
+			{
+				reply <- c.flush()
+			}
 		case <-c.ctx.Done():
 			{
-				log.Println("CSV backend: context cancelled, quitting ...")
+				logger.Debug("context cancelled, quitting ...")
 				goto TheEnd
 			}
 		}
 	}
 
 TheEnd:
-	log.Println("CSV backend done")
-	c.writer.Flush()
+	logger.Info("backend done")
+	c.flush()
 
 	return err
 }
+
+// Flush writes the buffered values to the file
+func (c *CSVBackend) Flush() error {
+	return requestFlush(c.flushes, c.done)
+}
+
+func (c *CSVBackend) flush() error {
+
+	c.writer.Flush()
+	err := c.writer.Error()
+	if err != nil {
+		return err
+	}
+
+	return c.file.Sync()
+}
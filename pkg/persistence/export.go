@@ -0,0 +1,304 @@
+package persistence
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// ExportFormats lists the formats a recording can be written in
+var ExportFormats = []string{"csv", "jsonl", "parquet", "sqlite"}
+
+// WriteRecording writes a recording into `outdir` in one of the ExportFormats.
+// The files are named like the ones the backends create, so the result can be read again with ReadRecording
+// if the format is csv or sqlite.
+func WriteRecording(ctx context.Context, outdir string, rec *Recording, format string) error {
+
+	err := os.MkdirAll(outdir, fs.ModePerm)
+	if err != nil {
+		slog.Error("could not create output directory", "dir", outdir, "err", err)
+		return err
+	}
+
+	switch format {
+	case "csv":
+		err = writeCSV(outdir, rec)
+	case "jsonl":
+		err = writeJSONL(outdir, rec)
+	case "parquet":
+		err = writeParquet(outdir, rec)
+	case "sqlite":
+		err = writeSqlite(ctx, outdir, rec)
+	default:
+		err = fmt.Errorf("unknown export format %s", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return WriteRun(outdir, rec.Run)
+}
+
+// typedValue converts a recorded value into an int64, float64 or string depending on the column type.
+// Empty values become nil.
+func typedValue(value, columnType string) any {
+
+	if value == "" {
+		return nil
+	}
+
+	switch {
+	case isText(columnType):
+		return value
+	case columnType == "INTEGER":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return int64(n) // wraps, but only values above math.MaxInt64 are affected
+		}
+	case columnType == "FLOAT":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
+
+func writeCSV(outdir string, rec *Recording) error {
+
+	for _, table := range rec.Tables {
+
+		name, err := measurements.GetFileName(table.Type)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(path.Join(outdir, name))
+		if err != nil {
+			slog.Error("could not create output file", "err", err)
+			return err
+		}
+
+		writer := csv.NewWriter(file)
+		writer.Write(table.Columns)
+
+		for _, row := range table.Rows {
+			// quote text the same way the CSV backend does
+			record := make([]string, len(row))
+			for i, value := range row {
+				if isText(table.Types[i]) {
+					value = fmt.Sprintf("'%s'", value)
+				}
+				record[i] = value
+			}
+			writer.Write(record)
+		}
+
+		writer.Flush()
+		err = writer.Error()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJSONL(outdir string, rec *Recording) error {
+
+	for _, table := range rec.Tables {
+
+		name, err := measurements.GetFileName(table.Type)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(path.Join(outdir, name+".jsonl"))
+		if err != nil {
+			slog.Error("could not create output file", "err", err)
+			return err
+		}
+
+		encoder := json.NewEncoder(file)
+		for _, row := range table.Rows {
+			object := make(map[string]any, len(row))
+			for i, value := range row {
+				v := typedValue(value, table.Types[i])
+				// JSON has no NaN or infinity
+				if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+					v = nil
+				}
+				object[table.Columns[i]] = v
+			}
+			err = encoder.Encode(object)
+			if err != nil {
+				break
+			}
+		}
+
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeParquet(outdir string, rec *Recording) error {
+
+	for _, table := range rec.Tables {
+
+		name, err := measurements.GetFileName(table.Type)
+		if err != nil {
+			return err
+		}
+
+		group := parquet.Group{}
+		for i, column := range table.Columns {
+			switch {
+			case isText(table.Types[i]):
+				group[column] = parquet.Optional(parquet.String())
+			case table.Types[i] == "INTEGER":
+				group[column] = parquet.Optional(parquet.Leaf(parquet.Int64Type))
+			case table.Types[i] == "FLOAT":
+				group[column] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+			default:
+				group[column] = parquet.Optional(parquet.String())
+			}
+		}
+		schema := parquet.NewSchema(name, group)
+
+		// parquet orders the columns of a group by name
+		index := make([]int, 0, len(table.Columns))
+		for _, columnPath := range schema.Columns() {
+			index = append(index, table.Column(columnPath[0]))
+		}
+
+		file, err := os.Create(path.Join(outdir, name+".parquet"))
+		if err != nil {
+			slog.Error("could not create output file", "err", err)
+			return err
+		}
+
+		writer := parquet.NewWriter(file, schema)
+
+		rows := make([]parquet.Row, 0, len(table.Rows))
+		for _, row := range table.Rows {
+			prow := make(parquet.Row, len(index))
+			for columnIndex, i := range index {
+				var value parquet.Value
+				switch v := typedValue(row[i], table.Types[i]).(type) {
+				case nil:
+					value = parquet.NullValue()
+				case int64:
+					value = parquet.Int64Value(v)
+				case float64:
+					value = parquet.DoubleValue(v)
+				case string:
+					value = parquet.ByteArrayValue([]byte(v))
+				}
+				definitionLevel := 1
+				if value.IsNull() {
+					definitionLevel = 0
+				}
+				prow[columnIndex] = value.Level(0, definitionLevel, columnIndex)
+			}
+			rows = append(rows, prow)
+		}
+
+		_, err = writer.WriteRows(rows)
+		if err == nil {
+			err = writer.Close()
+		}
+		file.Close()
+		if err != nil {
+			slog.Error("could not write parquet file", "type", table.Type, "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSqlite(ctx context.Context, outdir string, rec *Recording) error {
+
+	db, err := getDB(ctx, path.Join(outdir, "data.db"))
+	if err != nil {
+		slog.Error("could not open database", "err", err)
+		return err
+	}
+	defer db.Close()
+
+	for _, table := range rec.Tables {
+
+		name, err := measurements.GetFileName(table.Type)
+		if err != nil {
+			return err
+		}
+
+		columns := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			columns[i] = fmt.Sprintf("%s %s", column, table.Types[i])
+		}
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n    %s\n);", name, strings.Join(columns, ",\n    "))
+		_, err = db.ExecContext(ctx, query)
+		if err != nil {
+			slog.Error("could not create table", "query", query, "err", err)
+			return err
+		}
+
+		// all rows of a table are inserted in one transaction, one per row would take ages
+		transaction, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			slog.Error("could not open new transaction", "err", err)
+			return err
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(table.Columns)), ", ")
+		insert, err := transaction.PrepareContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (%s) values (%s);", name, strings.Join(table.Columns, ", "), placeholders,
+		))
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
+
+		for _, row := range table.Rows {
+			args := make([]any, len(row))
+			for i, value := range row {
+				args[i] = typedValue(value, table.Types[i])
+			}
+			_, err = insert.ExecContext(ctx, args...)
+			if err != nil {
+				slog.Error("could not execute insert statement", "err", err)
+				insert.Close()
+				transaction.Rollback()
+				return err
+			}
+		}
+
+		insert.Close()
+		err = transaction.Commit()
+		if err != nil {
+			slog.Error("could not commit transaction", "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
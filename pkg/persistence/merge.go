@@ -0,0 +1,228 @@
+package persistence
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// MergeRecordings combines recordings of several hosts into one recording with an additional `host` column.
+// All rows are aligned onto a common grid of `interval`, which has to be whole seconds like the timestamps.
+// The values of each host (and text key, e.g., network interface) within a grid cell are aggregated into one row
+// as described by measurements.GetColumnAggregations. Cells without any values of a host have no row for that host.
+func MergeRecordings(recs []*Recording, interval time.Duration) (*Recording, error) {
+
+	if interval < time.Second || interval%time.Second != 0 {
+		return nil, fmt.Errorf("interval %s isn't a whole number of seconds", interval)
+	}
+	step := int64(interval / time.Second)
+
+	merged := &Recording{
+		Run: Run{ID: uuid.New().String()},
+	}
+
+	var hosts []string
+	for _, rec := range recs {
+		hosts = append(hosts, rec.Run.Host)
+		if merged.Run.Started.IsZero() || (!rec.Run.Started.IsZero() && rec.Run.Started.Before(merged.Run.Started)) {
+			merged.Run.Started = rec.Run.Started
+		}
+	}
+	merged.Run.Host = strings.Join(hosts, ",")
+
+	for _, mType := range measurements.AllTypes {
+
+		var tables []*Table
+		var hostsOfTables []string
+		for i, rec := range recs {
+			for j := range rec.Tables {
+				if rec.Tables[j].Type == mType {
+					tables = append(tables, &rec.Tables[j])
+					hostsOfTables = append(hostsOfTables, hosts[i])
+				}
+			}
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		merged.Tables = append(merged.Tables, resample(tables, hostsOfTables, step))
+	}
+
+	return merged, nil
+}
+
+// cell accumulates the values of one host and key within one grid cell
+type cell struct {
+	timestamp int64
+	host      string
+	keys      []string
+	sums      []float64
+	counts    []int
+	lasts     []float64 // the values of the latest row, for counters
+	lastTimes []int64
+}
+
+func resample(tables []*Table, hosts []string, step int64) Table {
+
+	// the first table defines the columns, the others are mapped by name
+	first := tables[0]
+	result := Table{
+		Type:    first.Type,
+		Columns: append([]string{"host"}, first.Columns...),
+		Types:   append([]string{"TEXT"}, first.Types...),
+	}
+
+	timestampColumn := first.Column("timestamp")
+
+	var keyColumns, valueColumns []int
+	for i := range first.Columns {
+		switch {
+		case i == timestampColumn:
+			continue
+		case isText(first.Types[i]):
+			keyColumns = append(keyColumns, i)
+		default:
+			valueColumns = append(valueColumns, i)
+		}
+	}
+
+	aggregations := columnAggregations(first)
+
+	cells := make(map[string]*cell)
+
+	for t, table := range tables {
+
+		index := make([]int, len(first.Columns))
+		for i, column := range first.Columns {
+			index[i] = table.Column(column)
+		}
+		if index[timestampColumn] < 0 {
+			continue
+		}
+
+		for _, row := range table.Rows {
+
+			timestamp, err := strconv.ParseInt(row[index[timestampColumn]], 10, 64)
+			if err != nil {
+				continue
+			}
+			exact := timestamp
+			timestamp = timestamp / step * step
+
+			keys := make([]string, len(keyColumns))
+			for k, column := range keyColumns {
+				if index[column] >= 0 {
+					keys[k] = row[index[column]]
+				}
+			}
+
+			id := strings.Join(append([]string{strconv.FormatInt(timestamp, 10), hosts[t]}, keys...), "\x00")
+			c, ok := cells[id]
+			if !ok {
+				c = &cell{
+					timestamp: timestamp,
+					host:      hosts[t],
+					keys:      keys,
+					sums:      make([]float64, len(valueColumns)),
+					counts:    make([]int, len(valueColumns)),
+					lasts:     make([]float64, len(valueColumns)),
+					lastTimes: make([]int64, len(valueColumns)),
+				}
+				cells[id] = c
+			}
+
+			for v, column := range valueColumns {
+				if index[column] < 0 {
+					continue
+				}
+				value, err := strconv.ParseFloat(row[index[column]], 64)
+				if err != nil || math.IsNaN(value) {
+					continue
+				}
+				c.sums[v] += value
+				c.counts[v]++
+				if c.counts[v] == 1 || exact >= c.lastTimes[v] {
+					c.lasts[v], c.lastTimes[v] = value, exact
+				}
+			}
+		}
+	}
+
+	ordered := make([]*cell, 0, len(cells))
+	for _, c := range cells {
+		ordered = append(ordered, c)
+	}
+	slices.SortFunc(ordered, func(a, b *cell) int {
+		if a.timestamp != b.timestamp {
+			return cmp.Compare(a.timestamp, b.timestamp)
+		}
+		if a.host != b.host {
+			return strings.Compare(a.host, b.host)
+		}
+		return slices.Compare(a.keys, b.keys)
+	})
+
+	for _, c := range ordered {
+
+		row := make([]string, len(first.Columns))
+		row[timestampColumn] = strconv.FormatInt(c.timestamp, 10)
+		for k, column := range keyColumns {
+			row[column] = c.keys[k]
+		}
+		for v, column := range valueColumns {
+			if c.counts[v] == 0 {
+				continue
+			}
+			var value float64
+			switch aggregations[column] {
+			case measurements.Delta:
+				value = c.sums[v]
+			case measurements.Counter:
+				value = c.lasts[v]
+			default:
+				value = c.sums[v] / float64(c.counts[v])
+			}
+			if first.Types[column] == "INTEGER" {
+				row[column] = strconv.FormatInt(int64(math.Round(value)), 10)
+			} else {
+				row[column] = strconv.FormatFloat(value, 'f', 4, 64)
+			}
+		}
+
+		result.Rows = append(result.Rows, append([]string{c.host}, row...))
+	}
+
+	return result
+}
+
+// columnAggregations looks up how each of a table's columns is aggregated.
+// Columns the measurement type doesn't have (anymore) are averaged.
+func columnAggregations(table *Table) []measurements.Aggregation {
+
+	aggregations := make([]measurements.Aggregation, len(table.Columns))
+
+	names, err := measurements.GetColumnNames(table.Type)
+	if err != nil {
+		return aggregations
+	}
+	known, err := measurements.GetColumnAggregations(table.Type)
+	if err != nil {
+		return aggregations
+	}
+
+	for i, column := range table.Columns {
+		if j := slices.Index(names, column); j >= 0 {
+			aggregations[i] = known[j]
+		}
+	}
+
+	return aggregations
+}
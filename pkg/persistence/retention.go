@@ -0,0 +1,269 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// Retention describes how long a sqlite backend keeps its data at each resolution.
+// Raw rows older than Raw are rolled up into 1-minute aggregates, 1-minute aggregates
+// older than Raw+Minute are rolled up into 1-hour aggregates, and 1-hour aggregates
+// older than Raw+Minute+Hour are deleted. A zero Minute or Hour window keeps that
+// resolution forever, a zero Raw window disables the maintenance altogether.
+type Retention struct {
+	Raw, Minute, Hour time.Duration
+}
+
+// Retentions maps measurement types to their retention, it can be used as a command line flag
+type Retentions map[measurements.MeasurementType]Retention
+
+func (r *Retentions) String() string {
+	var res string
+	for mType, retention := range *r {
+		res += fmt.Sprintf("%d:%s:%s:%s, ", mType, retention.Raw, retention.Minute, retention.Hour)
+	}
+	return res
+}
+
+// Set parses values of the form <type>:<raw>[:<minute>[:<hour>]], e.g., 0:1h:24h:720h
+func (r *Retentions) Set(value string) error {
+
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return errors.New("expected <type>:<raw>[:<minute>[:<hour>]]")
+	}
+
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+
+	var windows [3]time.Duration
+	for i, part := range parts[1:] {
+		windows[i], err = time.ParseDuration(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *r == nil {
+		*r = make(Retentions)
+	}
+	(*r)[measurements.MeasurementType(n)] = Retention{
+		Raw:    windows[0],
+		Minute: windows[1],
+		Hour:   windows[2],
+	}
+
+	return nil
+}
+
+// how often the maintenance loop checks for data to roll up
+const maintenanceInterval = time.Minute
+
+// maintain periodically rolls up and deletes old data until the context is cancelled
+func maintain(ctx context.Context, db *sql.DB, mType measurements.MeasurementType, retention Retention) {
+
+	logger := slog.With("maintenance", mType)
+	logger.Info("sqlite maintenance starting")
+
+	s, err := schemaOf(mType)
+	if err != nil {
+		logger.Error("unknown measurement type, stopping maintenance", "err", err)
+		return
+	}
+
+	err = createAggregateTables(ctx, db, s)
+	if err != nil {
+		logger.Error("could not create aggregate tables, stopping maintenance", "err", err)
+		return
+	}
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			{
+				err = rollup(ctx, db, s, retention, now)
+				if err != nil {
+					logger.Error("could not roll up data", "err", err)
+				}
+			}
+		case <-ctx.Done():
+			{
+				logger.Info("sqlite maintenance done")
+				return
+			}
+		}
+	}
+}
+
+// aggregateColumns splits a measurement type's columns into key columns (text, e.g., the network interface)
+// and value columns (numbers) which are aggregated. The timestamp is neither.
+func aggregateColumns(s schema) (keys, values, types []string) {
+
+	for i, name := range s.names {
+		switch {
+		case name == "timestamp":
+			continue
+		case isText(s.types[i]):
+			keys = append(keys, name)
+		default:
+			values = append(values, name)
+			types = append(types, s.types[i])
+		}
+	}
+
+	return keys, values, types
+}
+
+func createAggregateTables(ctx context.Context, db *sql.DB, s schema) error {
+
+	keys, values, types := aggregateColumns(s)
+
+	columns := []string{"timestamp INTEGER"}
+	for _, key := range keys {
+		columns = append(columns, fmt.Sprintf("%s TEXT", key))
+	}
+	columns = append(columns, "samples INTEGER")
+	for i, value := range values {
+		columns = append(columns,
+			fmt.Sprintf("%s_min %s", value, types[i]),
+			fmt.Sprintf("%s_max %s", value, types[i]),
+			fmt.Sprintf("%s_avg FLOAT", value),
+			fmt.Sprintf("%s_last %s", value, types[i]),
+		)
+	}
+
+	table := s.table
+	for _, suffix := range []string{"_1m", "_1h"} {
+		query := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s%s (\n    %s\n);",
+			table, suffix, strings.Join(columns, ",\n    "),
+		)
+		_, err := db.ExecContext(ctx, query)
+		if err != nil {
+			slog.Error("could not create aggregate table", "query", query, "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statement is a query that takes a cutoff timestamp as its only argument
+type statement struct {
+	query  string
+	cutoff int64
+}
+
+// rollup moves everything that has left its retention window to the next coarser resolution
+func rollup(ctx context.Context, db *sql.DB, s schema, retention Retention, now time.Time) error {
+
+	if retention.Raw <= 0 {
+		return nil
+	}
+
+	table := s.table
+
+	// only complete buckets are rolled up, otherwise a bucket would be aggregated twice
+	align := func(t time.Time, bucket int64) int64 {
+		return t.Unix() / bucket * bucket
+	}
+
+	queries := []statement{
+		{aggregateQuery(s, table, table+"_1m", 60, false), align(now.Add(-retention.Raw), 60)},
+		{fmt.Sprintf("DELETE FROM %s WHERE timestamp < ?;", table), align(now.Add(-retention.Raw), 60)},
+	}
+
+	if retention.Minute > 0 {
+		cutoff := align(now.Add(-retention.Raw-retention.Minute), 3600)
+		queries = append(queries,
+			statement{aggregateQuery(s, table+"_1m", table+"_1h", 3600, true), cutoff},
+			statement{fmt.Sprintf("DELETE FROM %s_1m WHERE timestamp < ?;", table), cutoff},
+		)
+
+		if retention.Hour > 0 {
+			cutoff = align(now.Add(-retention.Raw-retention.Minute-retention.Hour), 3600)
+			queries = append(queries, statement{fmt.Sprintf("DELETE FROM %s_1h WHERE timestamp < ?;", table), cutoff})
+		}
+	}
+
+	transaction, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		slog.Error("could not open new transaction", "err", err)
+		return err
+	}
+
+	for _, q := range queries {
+		_, err = transaction.ExecContext(ctx, q.query, q.cutoff)
+		if err != nil {
+			slog.Error("could not execute rollup statement", "query", q.query, "err", err)
+			transaction.Rollback()
+			return err
+		}
+	}
+
+	return transaction.Commit()
+}
+
+// aggregateQuery builds the statement that aggregates all rows of `from` older than the cutoff
+// into buckets of `bucket` seconds in `to`. If `aggregated` is true, `from` already is an aggregate table.
+func aggregateQuery(s schema, from, to string, bucket int, aggregated bool) string {
+
+	keys, values, _ := aggregateColumns(s)
+
+	columns := append(append([]string{"timestamp"}, keys...), "samples")
+	selects := append(append([]string{"bucket"}, keys...), "COUNT(*)")
+	if aggregated {
+		selects[len(selects)-1] = "SUM(samples)"
+	}
+
+	for _, value := range values {
+		columns = append(columns, value+"_min", value+"_max", value+"_avg", value+"_last")
+		if aggregated {
+			selects = append(selects,
+				fmt.Sprintf("MIN(%s_min)", value),
+				fmt.Sprintf("MAX(%s_max)", value),
+				fmt.Sprintf("SUM(%s_avg * samples) / SUM(samples)", value),
+				fmt.Sprintf("MAX(CASE WHEN rn = 1 THEN %s_last END)", value),
+			)
+		} else {
+			selects = append(selects,
+				fmt.Sprintf("MIN(%s)", value),
+				fmt.Sprintf("MAX(%s)", value),
+				fmt.Sprintf("AVG(%s)", value),
+				fmt.Sprintf("MAX(CASE WHEN rn = 1 THEN %s END)", value),
+			)
+		}
+	}
+
+	partition := append([]string{"bucket"}, keys...)
+
+	return fmt.Sprintf(`INSERT INTO %s (
+    %s
+) SELECT
+    %s
+FROM (
+    SELECT *, ROW_NUMBER() OVER (PARTITION BY %s ORDER BY timestamp DESC) AS rn
+    FROM (SELECT *, (timestamp / %d) * %d AS bucket FROM %s WHERE timestamp < ?)
+)
+GROUP BY %s;`,
+		to,
+		strings.Join(columns, ",\n    "),
+		strings.Join(selects, ",\n    "),
+		strings.Join(partition, ", "),
+		bucket, bucket, from,
+		strings.Join(partition, ", "),
+	)
+}
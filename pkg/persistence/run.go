@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Run identifies a single recording
+type Run struct {
+	ID      string    `json:"id"`      // uuid of the run, also part of the output directory's name
+	Host    string    `json:"host"`    // name of the host the run was recorded on
+	Started time.Time `json:"started"` // when the recording started
+
+	Trigger   string     `json:"trigger,omitempty"`   // what started persisting measurements if the recording waited for a trigger
+	Triggered *time.Time `json:"triggered,omitempty"` // when that happened
+
+	Errors     map[string]uint64 `json:"errors,omitempty"`      // number of failed collections per measurement type
+	StopReason string            `json:"stop_reason,omitempty"` // which condition ended the recording, e.g., duration or interrupt
+}
+
+// name of the file holding a run's metadata in its output directory
+const runFileName = "run.json"
+
+// WriteRun stores the run's metadata in the output directory
+func WriteRun(outdir string, run Run) error {
+
+	err := os.MkdirAll(outdir, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path.Join(outdir, runFileName), data, 0644)
+}
+
+// ReadRun reads a run's metadata from its output directory.
+// Recordings without metadata get the ID from the directory's name.
+func ReadRun(outdir string) (Run, error) {
+
+	var run Run
+
+	data, err := os.ReadFile(path.Join(outdir, runFileName))
+	if os.IsNotExist(err) {
+		run.ID = strings.TrimPrefix(path.Base(outdir), "output-")
+		return run, nil
+	}
+	if err != nil {
+		return run, err
+	}
+
+	err = json.Unmarshal(data, &run)
+
+	return run, err
+}
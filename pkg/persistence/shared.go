@@ -0,0 +1,426 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// SharedSqliteBackend writes the measurements of many runs and hosts into one sqlite database.
+// Every measurement row references its run and host, so runs can be compared with a single query.
+type SharedSqliteBackend struct {
+	ctx    context.Context
+	values <-chan measurements.Measurement
+	mType  measurements.MeasurementType
+	db     *sql.DB
+	schema schema
+	run    Run
+	hostID int64
+
+	flushes chan flushRequest
+	done    chan struct{}
+}
+
+// options appended to the shared database's path
+// the busy timeout is needed because every backend (and possibly other stattrack processes) writes to the same file,
+// immediate transactions keep two backends from migrating the schema at the same time
+const sharedOptions = "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+
+// sharedMigrations[i] migrates a shared database from schema version i to i+1.
+// The version is kept in the database's user_version, so databases created by earlier builds are updated when opened.
+// Databases created before the version was stored have version 0, their tables are the ones of the first migration.
+var sharedMigrations = [][]string{
+	{
+		`CREATE TABLE IF NOT EXISTS hosts (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);`,
+		`CREATE TABLE IF NOT EXISTS runs (
+    id TEXT PRIMARY KEY,
+    host_id INTEGER NOT NULL REFERENCES hosts(id),
+    started INTEGER NOT NULL
+);`,
+		`CREATE TABLE IF NOT EXISTS series (
+    run_id TEXT NOT NULL REFERENCES runs(id),
+    type INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY (run_id, type)
+);`,
+	},
+	{
+		`ALTER TABLE runs ADD COLUMN stop_reason TEXT;`,
+	},
+	{
+		`ALTER TABLE runs ADD COLUMN errors TEXT;`, // JSON object of the failed collections per measurement type
+	},
+	{
+		`ALTER TABLE runs ADD COLUMN trigger TEXT;`,
+		`ALTER TABLE runs ADD COLUMN triggered INTEGER;`,
+	},
+}
+
+// 1 db for all runs, one shared sqlite backend for each requested measurement type
+func NewSharedSqliteBackend(
+	ctx context.Context,
+	values <-chan measurements.Measurement,
+	dbPath string,
+	mType measurements.MeasurementType,
+	run Run,
+) (*SharedSqliteBackend, error) {
+
+	slog.Debug("creating new shared sqlite backend", "type", mType)
+
+	s, err := schemaOf(mType)
+	if err != nil {
+		return nil, err
+	}
+
+	DB, err := openShared(ctx, dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &SharedSqliteBackend{
+		ctx:    ctx,
+		values: values,
+		mType:  mType,
+		db:     DB,
+		schema: s,
+		run:    run,
+
+		flushes: make(chan flushRequest),
+		done:    make(chan struct{}),
+	}
+
+	err = b.createTable()
+	if err != nil {
+		slog.Error("could not create table", "db", dbPath, "err", err)
+		b.db.Close()
+		return nil, err
+	}
+
+	err = b.register()
+	if err != nil {
+		slog.Error("could not register run in shared database", "err", err)
+		b.db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// openShared opens the shared database and brings its run, host & series tables up to the current schema version
+func openShared(ctx context.Context, dbPath string) (*sql.DB, error) {
+
+	err := os.MkdirAll(path.Dir(dbPath), fs.ModePerm)
+	if err != nil {
+		slog.Error("could not create output directory", "err", err)
+		return nil, err
+	}
+
+	db, err := getDB(ctx, dbPath+sharedOptions)
+	if err != nil {
+		slog.Error("could not open database", "db", dbPath, "err", err)
+		return nil, err
+	}
+
+	err = migrate(ctx, db)
+	if err != nil {
+		slog.Error("could not migrate shared database", "db", dbPath, "err", err)
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate applies the migrations the database doesn't have yet
+func migrate(ctx context.Context, db *sql.DB) error {
+
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	var version int
+	err = transaction.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version >= len(sharedMigrations) {
+		return nil
+	}
+
+	slog.Info("migrating shared database", "from", version, "to", len(sharedMigrations))
+
+	var queries []string
+	for ; version < len(sharedMigrations); version++ {
+		queries = append(queries, sharedMigrations[version]...)
+	}
+	queries = append(queries, fmt.Sprintf("PRAGMA user_version = %d;", len(sharedMigrations)))
+
+	for _, query := range queries {
+		_, err = transaction.ExecContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("%s: %w", query, err)
+		}
+	}
+
+	return transaction.Commit()
+}
+
+// createTable creates the backend's measurement table. Columns that the measurement type gained
+// since the table was created by an earlier version of stattrack are added to it.
+func (b *SharedSqliteBackend) createTable() error {
+
+	transaction, err := b.db.BeginTx(b.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	queries := []string{
+		createTable(
+			b.schema,
+			"run_id TEXT NOT NULL REFERENCES runs(id)",
+			"host_id INTEGER NOT NULL REFERENCES hosts(id)",
+		),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_run_timestamp ON %s (run_id, timestamp);", b.schema.table, b.schema.table),
+	}
+	for _, query := range queries {
+		_, err = transaction.ExecContext(b.ctx, query)
+		if err != nil {
+			return fmt.Errorf("%s: %w", query, err)
+		}
+	}
+
+	// the measurement table's existing columns
+	rows, err := transaction.QueryContext(b.ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s');", b.schema.table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for i, name := range b.schema.names {
+		if existing[name] {
+			continue
+		}
+		slog.Info("adding column to shared database", "table", b.schema.table, "column", name)
+		_, err = transaction.ExecContext(b.ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", b.schema.table, name, b.schema.types[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return transaction.Commit()
+}
+
+// hostID adds a host to the database if it doesn't exist yet and returns its ID
+func hostID(ctx context.Context, db *sql.DB, host string) (int64, error) {
+
+	_, err := db.ExecContext(ctx, "INSERT INTO hosts (name) VALUES (?) ON CONFLICT (name) DO NOTHING;", host)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = db.QueryRowContext(ctx, "SELECT id FROM hosts WHERE name = ?;", host).Scan(&id)
+
+	return id, err
+}
+
+// WriteSharedRun stores a run's metadata in the runs table of a shared database, like WriteRun does for output
+// directories. An existing row of the run is updated, e.g., with the reason the recording stopped.
+func WriteSharedRun(ctx context.Context, dbPath string, run Run) error {
+
+	db, err := openShared(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	host, err := hostID(ctx, db, run.Host)
+	if err != nil {
+		return err
+	}
+
+	var errors sql.NullString
+	if len(run.Errors) > 0 {
+		data, err := json.Marshal(run.Errors)
+		if err != nil {
+			return err
+		}
+		errors = nullString(string(data))
+	}
+
+	var triggered sql.NullInt64
+	if run.Triggered != nil {
+		triggered = sql.NullInt64{Int64: run.Triggered.Unix(), Valid: true}
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		`INSERT INTO runs (id, host_id, started, stop_reason, errors, trigger, triggered) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    stop_reason = excluded.stop_reason,
+    errors = excluded.errors,
+    trigger = excluded.trigger,
+    triggered = excluded.triggered;`,
+		run.ID, host, run.Started.Unix(), nullString(run.StopReason), errors, nullString(run.Trigger), triggered,
+	)
+
+	return err
+}
+
+// ReadSharedRun reads the metadata of a run from the runs table of the shared database at dbPath,
+// sql.ErrNoRows is returned for unknown runs
+func ReadSharedRun(ctx context.Context, dbPath string, id string) (Run, error) {
+
+	run := Run{ID: id}
+
+	db, err := openShared(ctx, dbPath)
+	if err != nil {
+		return run, err
+	}
+	defer db.Close()
+
+	var (
+		started             int64
+		stopReason, trigger sql.NullString
+		errors              sql.NullString
+		triggered           sql.NullInt64
+	)
+	err = db.QueryRowContext(
+		ctx,
+		`SELECT hosts.name, runs.started, runs.stop_reason, runs.errors, runs.trigger, runs.triggered
+FROM runs JOIN hosts ON runs.host_id = hosts.id WHERE runs.id = ?;`,
+		id,
+	).Scan(&run.Host, &started, &stopReason, &errors, &trigger, &triggered)
+	if err != nil {
+		return run, err
+	}
+
+	run.Started = time.Unix(started, 0)
+	run.StopReason, run.Trigger = stopReason.String, trigger.String
+	if triggered.Valid {
+		t := time.Unix(triggered.Int64, 0)
+		run.Triggered = &t
+	}
+	if errors.Valid {
+		err = json.Unmarshal([]byte(errors.String), &run.Errors)
+	}
+
+	return run, err
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// register adds the backend's host, run and series to the database if they don't exist yet
+func (b *SharedSqliteBackend) register() error {
+
+	var err error
+
+	b.hostID, err = hostID(b.ctx, b.db, b.run.Host)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(
+		b.ctx,
+		"INSERT INTO runs (id, host_id, started) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING;",
+		b.run.ID, b.hostID, b.run.Started.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(
+		b.ctx,
+		"INSERT INTO series (run_id, type, name) VALUES (?, ?, ?) ON CONFLICT (run_id, type) DO NOTHING;",
+		b.run.ID, b.mType, b.schema.table,
+	)
+
+	return err
+}
+
+func (b *SharedSqliteBackend) Start() error {
+
+	logger := slog.With("backend", "shared sqlite", "type", b.mType)
+	logger.Info("backend starting")
+
+	var err error
+
+	defer close(b.done)
+
+	for {
+		select {
+		case value := <-b.values:
+			{
+				err = b.insert(value)
+				if err != nil {
+					logger.Error("could not insert values into DB", "err", err)
+				}
+			}
+		case reply := <-b.flushes:
+			{
+				reply <- nil
+			}
+		case <-b.ctx.Done():
+			{
+				logger.Debug("context cancelled, quitting ...")
+				goto TheEnd
+			}
+		}
+	}
+
+TheEnd:
+	logger.Info("backend done")
+	b.db.Close()
+
+	return err
+}
+
+// Flush waits until the values received so far are inserted, the backend doesn't buffer them
+func (b *SharedSqliteBackend) Flush() error {
+	return requestFlush(b.flushes, b.done)
+}
+
+func (b *SharedSqliteBackend) insert(value measurements.Measurement) error {
+
+	vals, err := value.Record()
+	if err != nil {
+		return err
+	}
+
+	args, err := insertArgs(b.schema, vals)
+	if err != nil {
+		return err
+	}
+
+	return execQuery(b.ctx, b.db, insertQuery(b.schema, "run_id", "host_id"), append([]any{b.run.ID, b.hostID}, args...)...)
+}
@@ -5,22 +5,24 @@ import (
 	"database/sql"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"path"
-	"reflect"
 	"strings"
+	"sync"
 
-	"github.com/fatih/color"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/valentin-carl/stattrack/pkg/measurements"
 )
 
 type SqliteBackend struct {
-	ctx    context.Context
-	values <-chan measurements.Measurement
-	mType  measurements.MeasurementType
-	db     *sql.DB
+	ctx       context.Context
+	values    <-chan measurements.Measurement
+	mType     measurements.MeasurementType
+	db        *sql.DB
+	retention Retention
+	flushes   chan flushRequest
+	done      chan struct{}
 }
 
 // 1 db but one sqlite backend for each requested measurement type
@@ -32,76 +34,110 @@ func NewSqliteBackend(
 	dbFilename string,
 ) (*SqliteBackend, error) {
 
-	log.Println("creating new sqlite backend")
+	slog.Debug("creating new sqlite backend", "type", mType)
 
 	// create directory to put DB into
 	err := os.MkdirAll(outdir, fs.ModePerm)
 	if err != nil {
-		color.Red("error occurred while trying to create output directory", err.Error())
+		slog.Error("could not create output directory", "dir", outdir, "err", err)
 		return nil, err
 	}
 
 	// create db path from outdir & dbFilename
 	dbPath := path.Join(outdir, dbFilename)
-	log.Println("dbPath:", dbPath)
+	slog.Debug("opening database", "db", dbPath)
 
-	DB, err := getDB(ctx, dbPath)
+	DB, err := getDB(ctx, dbPath+"?_busy_timeout=5000")
 	if err != nil {
-		color.Red("could not open database")
+		slog.Error("could not open database", "db", dbPath, "err", err)
 		return nil, err
 	}
 
 	b := &SqliteBackend{
-		ctx:    ctx,
-		values: values,
-		mType:  mType,
-		db:     DB,
+		ctx:     ctx,
+		values:  values,
+		mType:   mType,
+		db:      DB,
+		flushes: make(chan flushRequest),
+		done:    make(chan struct{}),
+	}
+
+	s, err := schemaOf(mType)
+	if err != nil {
+		return nil, err
 	}
 
 	// create tables
-	query := createTable[mType]
+	query := createTable(s)
 	_, err = b.db.ExecContext(ctx, query)
 	if err != nil {
-		color.Red("something went wrong while trying to create a table | query:", query)
+		slog.Error("could not create table", "query", query, "err", err)
 		return nil, err
 	}
 
 	return b, nil
 }
 
+// SetRetention enables rolling up old data into 1-minute and 1-hour aggregate tables, see Retention.
+// It has to be called before Start.
+func (b *SqliteBackend) SetRetention(retention Retention) {
+	b.retention = retention
+}
+
 func (b *SqliteBackend) Start() error {
 
-	log.Printf("sqlite backend for %d starting\n", b.mType)
+	logger := slog.With("backend", "sqlite", "type", b.mType)
+	logger.Info("backend starting")
 
 	var err error
 
+	defer close(b.done)
+
+	// the maintenance loop runs alongside the backend and stops with the same context
+	var maintenance sync.WaitGroup
+	if b.retention.Raw > 0 {
+		maintenance.Add(1)
+		go func() {
+			maintain(b.ctx, b.db, b.mType, b.retention)
+			maintenance.Done()
+		}()
+	}
+
 	for {
 		select {
 		case value := <-b.values:
 			{
-				// todo remove logging statement later
-				log.Println("inserting value into db")
+				logger.Debug("inserting value into db")
 				err = insertValue(b.ctx, value, b.db)
 				if err != nil {
-					color.Red("sqlite backend: error while trying to insert values into DB")
-					color.Red(err.Error())
+					logger.Error("could not insert values into DB", "err", err)
 				}
 			}
+		case reply := <-b.flushes:
+			{
+				reply <- nil
+			}
 		case <-b.ctx.Done():
 			{
-				log.Println("sqlite backend: context cancelled, quitting ...")
+				logger.Debug("context cancelled, quitting ...")
 				goto TheEnd
 			}
 		}
 	}
 
 TheEnd:
-	log.Println("sqlite backend done")
+	logger.Info("backend done")
+	maintenance.Wait()
 	b.db.Close()
 
 	return err
 }
 
+// Flush waits until the values received so far are inserted, the backend doesn't buffer them
+func (b *SqliteBackend) Flush() error {
+	return requestFlush(b.flushes, b.done)
+}
+
 //
 // DATABASE HELPER CODE
 //
@@ -110,138 +146,150 @@ func getDB(ctx context.Context, dbPath string) (*sql.DB, error) {
 
 	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", dbPath))
 	if err != nil {
-		log.Println("error creating database file")
+		slog.Error("could not create database file", "err", err)
 		return nil, err
 	}
 
 	err = db.PingContext(ctx)
 	if err != nil {
-		log.Println("could not establish database connection")
+		slog.Error("could not establish database connection", "err", err)
 		return nil, err
 	}
 
 	return db, nil
 }
 
-var createTable = map[measurements.MeasurementType]string{
-	0: `CREATE TABLE cpu (
-    timestamp INTEGER,
-    user INTEGER,
-    system INTEGER,
-    idle INTEGER,
-    nice INTEGER,
-    total INTEGER,
-    userp FLOAT,
-    systemp FLOAT,
-    idlep FLOAT
-);`,
-	1: `CREATE TABLE memory (
-    timestamp INTEGER,
-    free INTEGER,
-    total INTEGER,
-    active INTEGER,
-    cached INTEGER,
-    inactive INTEGER,
-    swapFree INTEGER,
-    swapTotal INTEGER,
-    swapUsed INTEGER,
-    used INTEGER,
-    freep FLOAT
-);`,
-	2: `CREATE TABLE network (
-    timestamp INTEGER,
-    name TINYTEXT,
-    RxBytes INTEGER,
-    TxBytes INTEGER
-);`,
+// schema is the table name and the columns of a measurement type
+type schema struct {
+	table string
+	names []string
+	types []string
 }
 
-func insertValue(ctx context.Context, value measurements.Measurement, db *sql.DB) error {
+func schemaOf(mType measurements.MeasurementType) (schema, error) {
 
 	var (
+		s   schema
 		err error
-		t   measurements.MeasurementType
 	)
 
-	switch value.(type) {
-	case measurements.CPUMeasurement:
-		t = 0
-	case measurements.MemoryMeasurement:
-		t = 1
-	case measurements.NetworkMeasurement:
-		t = 2
-	default:
-		fmt.Println(value)
-		color.Red(reflect.TypeOf(value).String())
-		panic("fatal error")
+	s.table, err = measurements.GetFileName(mType)
+	if err != nil {
+		return s, err
+	}
+
+	s.names, err = measurements.GetColumnNames(mType)
+	if err != nil {
+		return s, err
+	}
+
+	s.types, err = measurements.GetColumnTypes(mType)
+
+	return s, err
+}
+
+// createTable builds the CREATE TABLE statement for a measurement type.
+// `extra` column definitions are put in front of the measurement's own columns.
+func createTable(s schema, extra ...string) string {
+
+	columns := append([]string{}, extra...)
+	for i := range s.names {
+		columns = append(columns, fmt.Sprintf("%s %s", s.names[i], s.types[i]))
+	}
+
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (\n    %s\n);",
+		s.table,
+		strings.Join(columns, ",\n    "),
+	)
+}
+
+// insertQuery builds the INSERT statement for a measurement type.
+// `extra` column names are put in front of the measurement's own columns,
+// all values are bound as query arguments, see insertArgs.
+func insertQuery(s schema, extra ...string) string {
+
+	columns := append(append([]string{}, extra...), s.names...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (\n    %s\n) values (\n    %s\n);",
+		s.table,
+		strings.Join(columns, ",\n    "),
+		placeholders,
+	)
+}
+
+// insertArgs turns a measurement's record into the arguments of insertQuery.
+// Text values lose the quotes added by Measurement.Record, the others have to be numbers.
+func insertArgs(s schema, values []string) ([]any, error) {
+
+	if len(values) != len(s.names) {
+		return nil, fmt.Errorf("%s: expected %d values, got %d", s.table, len(s.names), len(values))
+	}
+
+	args := make([]any, len(values))
+	for i, value := range values {
+		if isText(s.types[i]) {
+			value = strings.Trim(value, "'")
+		}
+		args[i] = typedValue(value, s.types[i])
+		if _, ok := args[i].(string); ok && !isText(s.types[i]) {
+			return nil, fmt.Errorf("%s: %s is not a number: %q", s.table, s.names[i], value)
+		}
+	}
+
+	return args, nil
+}
+
+func insertValue(ctx context.Context, value measurements.Measurement, db *sql.DB) error {
+
+	t, err := measurements.TypeOf(value)
+	if err != nil {
+		return err
+	}
+
+	s, err := schemaOf(t)
+	if err != nil {
+		return err
 	}
 
 	vals, err := value.Record()
 	if err != nil {
-		log.Println(color.YellowString("cannot insert NaN values |", err.Error()))
 		return err
 	}
 
-	query := fmt.Sprintf(insert[t], strings.Join(vals, ", "))
+	args, err := insertArgs(s, vals)
+	if err != nil {
+		return err
+	}
+
+	return execQuery(ctx, db, insertQuery(s), args...)
+}
 
-	log.Println("executing query:", query)
+// execQuery runs a single statement with its arguments in its own transaction
+func execQuery(ctx context.Context, db *sql.DB, query string, args ...any) error {
+
+	slog.Debug("executing query", "query", query)
 
 	transaction, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
-		log.Println("could not open new transaction")
+		slog.Error("could not open new transaction", "err", err)
 		return err
 	}
 
-	_, err = transaction.ExecContext(ctx, query)
+	_, err = transaction.ExecContext(ctx, query, args...)
 	if err != nil {
-		log.Println("error while executing insert statement")
+		slog.Error("could not execute statement", "err", err)
+		transaction.Rollback()
 		return err
 	}
 
 	err = transaction.Commit()
 	if err != nil {
-		log.Println("error while commiting transaction")
+		slog.Error("could not commit transaction", "err", err)
 		return err
 	}
 
 	return err
 }
-
-var insert = map[measurements.MeasurementType]string{
-	0: `INSERT INTO cpu (
-    timestamp,
-    user,
-    system,
-    idle,
-    nice,
-    total,
-    userp,
-    systemp,
-    idlep
-) values (
-    %s
-);`,
-	1: `INSERT INTO memory (
-    timestamp,
-    free,
-    total,
-    active,
-    cached,
-    inactive,
-    swapFree,
-    swapTotal,
-    swapUsed,
-    used,
-    freep
-) values (
-    %s
-);`,
-	2: `INSERT INTO network (
-    timestamp,
-    name,
-    RxBytes,
-    TxBytes
-) values (
-    %s
-);`,
-}
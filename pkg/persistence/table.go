@@ -0,0 +1,318 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+)
+
+// Table holds all rows of one measurement type as they were recorded
+type Table struct {
+	Type    measurements.MeasurementType
+	Columns []string
+	Types   []string   // sqlite column types, see measurements.GetColumnTypes
+	Rows    [][]string // text values are stored without the quotes added by Measurement.Record
+}
+
+// Recording is a run's output directory read back into memory
+type Recording struct {
+	Run    Run
+	Tables []Table
+}
+
+// Column returns the index of a column or -1 if the table doesn't have it
+func (t *Table) Column(name string) int {
+	for i, column := range t.Columns {
+		if column == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// isText reports whether a sqlite column type holds text
+func isText(columnType string) bool {
+	return strings.HasSuffix(columnType, "TEXT")
+}
+
+// columnType looks up a column's type in the current definition of the measurement type.
+// Unknown columns are treated as text, so they are preserved as they are.
+func columnType(s schema, column string) string {
+	for i, name := range s.names {
+		if name == column {
+			return s.types[i]
+		}
+	}
+	return "TEXT"
+}
+
+// ReadRecording reads a recording written by either the CSV or the sqlite backend
+func ReadRecording(ctx context.Context, dir string) (*Recording, error) {
+
+	run, err := ReadRun(dir)
+	if err != nil {
+		slog.Error("could not read run metadata", "dir", dir, "err", err)
+		return nil, err
+	}
+
+	rec := &Recording{Run: run}
+
+	dbPath := path.Join(dir, "data.db")
+	if _, err := os.Stat(dbPath); err == nil {
+		rec.Tables, err = readSqlite(ctx, dbPath)
+	} else {
+		rec.Tables, err = readCSV(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rec.Tables) == 0 {
+		return nil, fmt.Errorf("no recorded measurements found in %s", dir)
+	}
+
+	return rec, nil
+}
+
+func readCSV(dir string) ([]Table, error) {
+
+	var tables []Table
+
+	for _, mType := range measurements.StoredTypes() {
+
+		s, err := schemaOf(mType)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := os.Open(path.Join(dir, s.table))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			slog.Error("could not read csv file", "type", mType, "err", err)
+			return nil, err
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		table := Table{
+			Type:    mType,
+			Columns: records[0],
+			Rows:    records[1:],
+		}
+		for _, column := range table.Columns {
+			table.Types = append(table.Types, columnType(s, column))
+		}
+
+		// the CSV backend quotes text like the sqlite backend does
+		for _, row := range table.Rows {
+			for i := range row {
+				if i < len(table.Types) && isText(table.Types[i]) {
+					row[i] = strings.Trim(row[i], "'")
+				}
+			}
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+func readSqlite(ctx context.Context, dbPath string) ([]Table, error) {
+
+	db, err := getDB(ctx, dbPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var tables []Table
+
+	for _, mType := range measurements.StoredTypes() {
+
+		s, err := schemaOf(mType)
+		if err != nil {
+			return nil, err
+		}
+		name := s.table
+
+		var count int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;", name).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		table, err := readSqliteTable(ctx, db, mType, name)
+		if err != nil {
+			slog.Error("could not read table", "table", name, "err", err)
+			return nil, err
+		}
+
+		// with retention, the older rows have been rolled up into the aggregate tables
+		var older [][]string
+		for _, suffix := range []string{"_1h", "_1m"} {
+			rows, err := readRollup(ctx, db, &table, name+suffix)
+			if err != nil {
+				slog.Error("could not read aggregate table", "table", name+suffix, "err", err)
+				return nil, err
+			}
+			if len(rows) > 0 {
+				slog.Info("reading aggregated rows", "table", name+suffix, "rows", len(rows))
+			}
+			older = append(older, rows...)
+		}
+		table.Rows = append(older, table.Rows...)
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// readRollup reads an aggregate table created by the retention maintenance as rows of the raw `table`, one per bucket.
+// Each value is turned back into what a raw row would hold, see measurements.GetColumnAggregations:
+// gauges get the bucket's average, deltas its sum and counters its last value.
+func readRollup(ctx context.Context, db *sql.DB, table *Table, name string) ([][]string, error) {
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;", name).Scan(&count)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	rollup, err := readSqliteTable(ctx, db, table.Type, name)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := rollup.Column("samples")
+	aggregations := columnAggregations(table)
+
+	rows := make([][]string, 0, len(rollup.Rows))
+	for _, aggregated := range rollup.Rows {
+
+		row := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+
+			if column == "timestamp" || isText(table.Types[i]) {
+				if j := rollup.Column(column); j >= 0 {
+					row[i] = aggregated[j]
+				}
+				continue
+			}
+
+			var value float64
+			switch aggregations[i] {
+			case measurements.Counter:
+				j := rollup.Column(column + "_last")
+				if j < 0 || aggregated[j] == "" {
+					continue
+				}
+				value, err = strconv.ParseFloat(aggregated[j], 64)
+			default:
+				j := rollup.Column(column + "_avg")
+				if j < 0 || aggregated[j] == "" {
+					continue
+				}
+				value, err = strconv.ParseFloat(aggregated[j], 64)
+				if err == nil && aggregations[i] == measurements.Delta && samples >= 0 {
+					var n float64
+					n, err = strconv.ParseFloat(aggregated[samples], 64)
+					value *= n
+				}
+			}
+			if err != nil {
+				continue
+			}
+
+			if table.Types[i] == "INTEGER" {
+				row[i] = strconv.FormatInt(int64(math.Round(value)), 10)
+			} else {
+				row[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func readSqliteTable(ctx context.Context, db *sql.DB, mType measurements.MeasurementType, name string) (Table, error) {
+
+	table := Table{Type: mType}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s ORDER BY timestamp;", name))
+	if err != nil {
+		return table, err
+	}
+	defer rows.Close()
+
+	table.Columns, err = rows.Columns()
+	if err != nil {
+		return table, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return table, err
+	}
+	for _, t := range columnTypes {
+		table.Types = append(table.Types, t.DatabaseTypeName())
+	}
+
+	values := make([]any, len(table.Columns))
+	pointers := make([]any, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return table, err
+		}
+
+		row := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				row[i] = ""
+			case int64:
+				row[i] = strconv.FormatInt(v, 10)
+			case float64:
+				row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case []byte:
+				row[i] = string(v)
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, rows.Err()
+}
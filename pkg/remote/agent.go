@@ -0,0 +1,353 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+	maxBackoff   = 30 * time.Second
+	drainTimeout = 5 * time.Second // how long ending a run waits for the server's acknowledgements
+)
+
+// connection events of an agent, they carry the connection they're about, so events of old connections can be ignored
+type (
+	dialed struct {
+		conn net.Conn
+		err  error
+	}
+	received struct {
+		conn  net.Conn
+		frame Frame
+		err   error
+	}
+)
+
+// Agent sends the measurements of a run to a server. While the server is unreachable, the measurements are spooled
+// to disk and sent once the agent has reconnected.
+type Agent struct {
+	addr   string
+	tls    *tls.Config // nil for plain TCP
+	run    persistence.Run
+	spool  spool
+	ending chan persistence.Run
+	done   chan struct{}
+
+	conn    net.Conn
+	seq     uint64
+	hellos  map[string]Frame // by run, including those of runs found in the spool
+	unacked []Frame          // sent but not acknowledged yet
+	spooled bool             // whether the spool has frames to send
+	events  chan received
+}
+
+// NewAgent creates an agent that connects to `addr`, using TLS if `tlsConfig` isn't nil,
+// and spools to `spoolDir`. Runs spooled there by earlier agents are sent, too.
+func NewAgent(addr string, tlsConfig *tls.Config, run persistence.Run, spoolDir string) (*Agent, error) {
+
+	// the server wouldn't accept the run
+	err := checkRun(run)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSpool(spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := s.runs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		addr:    addr,
+		tls:     tlsConfig,
+		run:     run,
+		spool:   s,
+		ending:  make(chan persistence.Run),
+		done:    make(chan struct{}),
+		hellos:  map[string]Frame{run.ID: {Kind: hello, Run: run.ID, Info: &run}},
+		events:  make(chan received),
+		spooled: len(runs) > 0,
+	}, nil
+}
+
+// Start sends the measurements from `values` until the run is ended with End or the context is cancelled.
+// Undelivered measurements are left in the spool.
+func (a *Agent) Start(ctx context.Context, values <-chan measurements.Measurement) error {
+
+	defer close(a.done)
+
+	logger := slog.With("server", a.addr)
+	logger.Info("agent starting", "run", a.run.ID)
+
+	dials := make(chan dialed)
+	retry := time.After(0)
+	backoff := time.Second
+	dialing := false
+
+	var drain <-chan time.Time // set once the run has ended
+
+	for {
+		// an ended run is done once the server has acknowledged everything
+		if drain != nil && !a.spooled && len(a.unacked) == 0 {
+			a.disconnect()
+			logger.Info("agent done")
+			return nil
+		}
+
+		// whatever broke the connection, the agent reconnects
+		if a.conn == nil && !dialing && retry == nil {
+			retry = time.After(backoff)
+			backoff = min(2*backoff, maxBackoff)
+		}
+
+		select {
+		case value := <-values:
+			{
+				frame, err := a.frame(value)
+				if err != nil {
+					logger.Error("could not send measurement", "err", err)
+					continue
+				}
+				a.send(frame)
+			}
+		case info := <-a.ending:
+			{
+				a.seq++
+				a.send(Frame{Kind: end, Run: info.ID, Info: &info, Seq: a.seq})
+				drain = time.After(drainTimeout)
+			}
+		case <-drain:
+			{
+				logger.Warn("server didn't acknowledge all measurements in time, they stay in the spool", "unacked", len(a.unacked))
+				a.disconnect()
+				return nil
+			}
+		case <-retry:
+			{
+				retry, dialing = nil, true
+				go func() {
+					conn, err := a.dial()
+					select {
+					case dials <- dialed{conn, err}:
+					case <-a.done:
+						if conn != nil {
+							conn.Close()
+						}
+					}
+				}()
+			}
+		case d := <-dials:
+			{
+				dialing = false
+				if d.err != nil {
+					logger.Warn("cannot connect to server, spooling", "err", d.err, "retry", backoff)
+					continue
+				}
+				logger.Info("connected to server")
+				backoff = time.Second
+				a.connect(d.conn)
+			}
+		case r := <-a.events:
+			{
+				if r.conn != a.conn {
+					continue // an old connection
+				}
+				if r.err != nil {
+					logger.Warn("lost connection to server, spooling", "err", r.err)
+					a.disconnect()
+					continue
+				}
+				if r.frame.Kind == ack {
+					a.acknowledge(r.frame)
+				}
+			}
+		case <-ctx.Done():
+			{
+				a.disconnect()
+				logger.Info("agent done")
+				return nil
+			}
+		}
+	}
+}
+
+// End sends the run's final metadata, e.g., its stop reason, and waits until the agent is done
+func (a *Agent) End(run persistence.Run) {
+	select {
+	case a.ending <- run:
+	case <-a.done:
+	}
+	<-a.done
+}
+
+func (a *Agent) frame(value measurements.Measurement) (Frame, error) {
+
+	mType, err := measurements.TypeOf(value)
+	if err != nil {
+		return Frame{}, err
+	}
+	record, err := value.Record()
+	if err != nil {
+		return Frame{}, err
+	}
+
+	// the server closes the connection on frames it doesn't accept
+	frame := Frame{Kind: data, Run: a.run.ID, Type: mType, Record: record}
+	err = checkRecord(frame)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	a.seq++
+	frame.Seq = a.seq
+
+	return frame, nil
+}
+
+func (a *Agent) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if a.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", a.addr, a.tls)
+	}
+	return dialer.Dial("tcp", a.addr)
+}
+
+// send writes a frame to the server or, without a connection, to the spool
+func (a *Agent) send(frame Frame) {
+
+	if a.conn == nil {
+		err := a.spool.add([]Frame{frame}, a.hellos)
+		if err != nil {
+			slog.Error("could not spool measurement, it's lost", "err", err)
+		}
+		a.spooled = true
+		return
+	}
+
+	a.unacked = append(a.unacked, frame)
+	a.write(frame)
+}
+
+// write writes a frame to the connection, a failed write ends the connection
+func (a *Agent) write(frame Frame) bool {
+
+	if a.conn == nil {
+		return false
+	}
+
+	a.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	err := writeFrame(a.conn, frame)
+	if err != nil {
+		slog.Warn("lost connection to server, spooling", "server", a.addr, "err", err)
+		a.disconnect()
+		return false
+	}
+
+	return true
+}
+
+// connect announces the run and sends what was spooled
+func (a *Agent) connect(conn net.Conn) {
+
+	a.conn = conn
+
+	// the server's acknowledgements are read alongside
+	go func() {
+		for {
+			frame, err := readFrame(conn)
+			select {
+			case a.events <- received{conn, frame, err}:
+			case <-a.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	if !a.write(a.hellos[a.run.ID]) {
+		return
+	}
+
+	runs, err := a.spool.runs()
+	if err != nil {
+		slog.Error("could not read spool", "err", err)
+		return
+	}
+
+	for _, run := range runs {
+
+		frames, err := a.spool.take(run)
+		if err != nil {
+			slog.Error("could not read spool", "run", run, "err", err)
+			continue
+		}
+		slog.Info("sending spooled measurements", "run", run, "frames", len(frames))
+
+		// the frames are unacknowledged from now on, so they're spooled again if the connection breaks
+		start := len(a.unacked)
+		for _, frame := range frames {
+			switch {
+			case frame.Kind == hello:
+				a.hellos[frame.Run] = frame
+			case frame.Kind == data && checkRecord(frame) != nil:
+				// e.g., the gaps spooled by earlier agents, the server would close the connection
+				slog.Warn("dropping spooled measurement the server doesn't accept", "run", run, "seq", frame.Seq, "type", frame.Type)
+			default:
+				a.unacked = append(a.unacked, frame)
+			}
+		}
+		for _, frame := range frames {
+			if frame.Kind == hello && run != a.run.ID {
+				if !a.write(frame) {
+					return
+				}
+			}
+		}
+		for _, frame := range a.unacked[start:] {
+			if !a.write(frame) {
+				return
+			}
+		}
+	}
+
+	a.spooled = false
+}
+
+// disconnect closes the connection and spools the unacknowledged frames
+func (a *Agent) disconnect() {
+
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+
+	if len(a.unacked) > 0 {
+		err := a.spool.add(a.unacked, a.hellos)
+		if err != nil {
+			slog.Error("could not spool measurements, they're lost", "frames", len(a.unacked), "err", err)
+		}
+		a.unacked = nil
+		a.spooled = true
+	}
+}
+
+// acknowledge forgets the frames the server has acknowledged
+func (a *Agent) acknowledge(frame Frame) {
+	a.unacked = slices.DeleteFunc(a.unacked, func(f Frame) bool {
+		return f.Run == frame.Run && f.Seq <= frame.Seq
+	})
+}
@@ -0,0 +1,142 @@
+// Package remote streams measurements from agents on many hosts to a central server.
+//
+// Agent and server exchange frames over TCP, each a 4-byte big-endian length followed by a JSON object.
+// An agent announces its run with a hello frame and sends every measurement in a data frame
+// with a sequence number, which the server acknowledges once the backend has written the measurement.
+package remote
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// kinds of frames
+const (
+	hello = "hello" // agent announces a run
+	data  = "data"  // agent sends a measurement
+	end   = "end"   // agent ends a run
+	ack   = "ack"   // server acknowledges the data frames of a run up to a sequence number
+)
+
+// frames larger than this are rejected, measurements are much smaller
+const maxFrameSize = 1 << 20
+
+// Frame is the unit of the protocol
+type Frame struct {
+	Kind   string                       `json:"kind"`
+	Run    string                       `json:"run"`              // ID of the run the frame belongs to
+	Info   *persistence.Run             `json:"info,omitempty"`   // hello and end
+	Seq    uint64                       `json:"seq,omitempty"`    // data and ack
+	Type   measurements.MeasurementType `json:"type,omitempty"`   // data
+	Record []string                     `json:"record,omitempty"` // data, see measurements.Measurement
+}
+
+func writeFrame(w io.Writer, frame Frame) error {
+
+	body, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+
+	_, err = w.Write(append(buf, body...))
+	return err
+}
+
+func readFrame(r io.Reader) (Frame, error) {
+
+	var frame Frame
+
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return frame, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return frame, fmt.Errorf("frame of %d bytes exceeds the maximum of %d", n, maxFrameSize)
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return frame, err
+	}
+
+	err = json.Unmarshal(body, &frame)
+
+	return frame, err
+}
+
+// checkRecord rejects data frames whose record doesn't fit the columns of its type,
+// so nothing an agent sends reaches a backend unchecked. Internal types, e.g., gaps, aren't accepted from agents.
+func checkRecord(frame Frame) error {
+
+	if !frame.Type.Valid() {
+		return fmt.Errorf("%w: %d", measurements.ErrUnknownType, frame.Type)
+	}
+
+	names, err := measurements.GetColumnNames(frame.Type)
+	if err != nil {
+		return err
+	}
+	types, err := measurements.GetColumnTypes(frame.Type)
+	if err != nil {
+		return err
+	}
+	if len(frame.Record) != len(names) {
+		return fmt.Errorf("expected %d values of type %d, got %d", len(names), frame.Type, len(frame.Record))
+	}
+
+	for i, value := range frame.Record {
+		switch {
+		case strings.HasSuffix(types[i], "TEXT"):
+			// text is quoted like Measurement.Record does, quotes inside are removed
+			text, ok := strings.CutPrefix(value, "'")
+			text, ok2 := strings.CutSuffix(text, "'")
+			if !ok || !ok2 || strings.Contains(text, "'") {
+				return fmt.Errorf("%s is not quoted text: %q", names[i], value)
+			}
+		case types[i] == "INTEGER":
+			_, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				_, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return fmt.Errorf("%s is not an integer: %q", names[i], value)
+			}
+		default:
+			_, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s is not a number: %q", names[i], value)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRun rejects runs whose ID or host name can't be used as a directory name,
+// the server stores a run in <dir>/<host>/output-<ID>
+func checkRun(run persistence.Run) error {
+
+	if id, err := uuid.Parse(run.ID); err != nil || id.String() != run.ID {
+		return fmt.Errorf("run ID %q is not a UUID", run.ID)
+	}
+	if run.Host == "" || run.Host == "." || run.Host == ".." || strings.ContainsAny(run.Host, "/\\\x00") {
+		return fmt.Errorf("invalid host name %q", run.Host)
+	}
+
+	return nil
+}
@@ -0,0 +1,515 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// recorder stands in for the persistence backends and keeps the received records in memory
+type recorder struct {
+	mu      sync.Mutex
+	records map[measurements.MeasurementType][][]string
+	runs    []persistence.Run
+}
+
+func newRecorder() *recorder {
+	return &recorder{records: make(map[measurements.MeasurementType][][]string)}
+}
+
+type recorderBackend struct {
+	ctx    context.Context
+	r      *recorder
+	mType  measurements.MeasurementType
+	values <-chan measurements.Measurement
+}
+
+func (b recorderBackend) Start() error {
+	for {
+		select {
+		case value := <-b.values:
+			record, _ := value.Record()
+			b.r.mu.Lock()
+			b.r.records[b.mType] = append(b.r.records[b.mType], record)
+			b.r.mu.Unlock()
+		case <-b.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *recorder) newBackend(ctx context.Context, run persistence.Run, mType measurements.MeasurementType, values <-chan measurements.Measurement) (persistence.Backend, error) {
+	return recorderBackend{ctx, r, mType, values}, nil
+}
+
+func (r *recorder) writeRun(run persistence.Run) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, run)
+	return nil
+}
+
+// readRun returns the last metadata written for a run
+func (r *recorder) readRun(run persistence.Run) (persistence.Run, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.runs) - 1; i >= 0; i-- {
+		if r.runs[i].ID == run.ID {
+			return r.runs[i], nil
+		}
+	}
+	return run, os.ErrNotExist
+}
+
+func (r *recorder) count(mType measurements.MeasurementType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.records[mType])
+}
+
+// serve starts a server on `addr` until the test is done
+func serve(t *testing.T, addr string, r *recorder) (net.Listener, context.CancelFunc) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewServer(r.newBackend, r.writeRun, r.readRun).Serve(ctx, listener)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return listener, cancel
+}
+
+// record returns a record that fits the columns of `mType` with `i` as its timestamp
+func record(mType measurements.MeasurementType, i int) []string {
+	types, _ := measurements.GetColumnTypes(mType)
+	values := make([]string, len(types))
+	for j, columnType := range types {
+		if strings.HasSuffix(columnType, "TEXT") {
+			values[j] = "'host'"
+		} else {
+			values[j] = "0"
+		}
+	}
+	values[0] = fmt.Sprint(i)
+	return values
+}
+
+func row(mType measurements.MeasurementType, i int) measurements.Row {
+	return measurements.Row{Type: mType, Values: record(mType, i)}
+}
+
+func TestAgentServer(t *testing.T) {
+
+	r := newRecorder()
+	listener, _ := serve(t, "127.0.0.1:0", r)
+
+	run := persistence.Run{ID: uuid.NewString(), Host: "host-1", Started: time.Now()}
+	spoolDir := t.TempDir()
+	agent, err := NewAgent(listener.Addr().String(), nil, run, spoolDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(chan measurements.Measurement)
+	go agent.Start(context.Background(), values)
+
+	for i := 0; i < 5; i++ {
+		values <- row(measurements.CPU, i)
+		values <- row(measurements.MEM, i)
+	}
+
+	run.StopReason = "duration"
+	agent.End(run)
+
+	for _, mType := range []measurements.MeasurementType{measurements.CPU, measurements.MEM} {
+		if n := r.count(mType); n != 5 {
+			t.Fatalf("expected 5 records of type %d, got %d", mType, n)
+		}
+		for i, record := range r.records[mType] {
+			if record[0] != fmt.Sprint(i) {
+				t.Errorf("expected record %d, got %v", i, record)
+			}
+		}
+	}
+
+	// the run's metadata is written when it starts and again with the final values when it ends
+	if len(r.runs) != 2 || r.runs[1].StopReason != "duration" || r.runs[1].Host != "host-1" {
+		t.Errorf("unexpected run metadata %+v", r.runs)
+	}
+
+	entries, _ := os.ReadDir(spoolDir)
+	if len(entries) != 0 {
+		t.Errorf("expected an empty spool, found %d files", len(entries))
+	}
+}
+
+func TestAgentSpool(t *testing.T) {
+
+	// reserve an address, nobody listens on it yet
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	run := persistence.Run{ID: uuid.NewString(), Host: "host-2", Started: time.Now()}
+	spoolDir := t.TempDir()
+
+	// a run of an earlier agent that never reached the server
+	s, _ := newSpool(spoolDir)
+	earlier := persistence.Run{ID: uuid.NewString(), Host: "host-2"}
+	err = s.add(
+		[]Frame{{Kind: data, Run: earlier.ID, Seq: 1, Type: measurements.LOAD, Record: record(measurements.LOAD, 0)}},
+		map[string]Frame{earlier.ID: {Kind: hello, Run: earlier.ID, Info: &earlier}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent, err := NewAgent(addr, nil, run, spoolDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(chan measurements.Measurement)
+	go agent.Start(context.Background(), values)
+
+	for i := 0; i < 3; i++ {
+		values <- row(measurements.CPU, i)
+	}
+
+	r := newRecorder()
+	serve(t, addr, r)
+
+	for i := 3; i < 6; i++ {
+		values <- row(measurements.CPU, i)
+	}
+
+	// the agent reconnects within its backoff
+	deadline := time.Now().Add(10 * time.Second)
+	for r.count(measurements.CPU) < 6 || r.count(measurements.LOAD) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 6 spooled and live records, got %d", r.count(measurements.CPU))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	agent.End(run)
+
+	var got []string
+	for _, record := range r.records[measurements.CPU] {
+		got = append(got, record[0])
+	}
+	if !slices.Equal(got, []string{"0", "1", "2", "3", "4", "5"}) {
+		t.Errorf("expected the records in order, got %v", got)
+	}
+}
+
+func TestFrames(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sent := Frame{Kind: data, Run: "run", Seq: 7, Type: measurements.NET, Record: []string{"1", "'eth0'"}}
+	go writeFrame(client, sent)
+
+	received, err := readFrame(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Kind != sent.Kind || received.Seq != sent.Seq || received.Type != sent.Type || !slices.Equal(received.Record, sent.Record) {
+		t.Errorf("expected %+v, got %+v", sent, received)
+	}
+}
+
+// bufferingBackend keeps the received records in a buffer until it's flushed, like the CSV backend
+type bufferingBackend struct {
+	recorderBackend
+	flushes chan chan error
+	buffer  [][]string
+}
+
+func (b *bufferingBackend) Start() error {
+	for {
+		select {
+		case value := <-b.values:
+			record, _ := value.Record()
+			b.buffer = append(b.buffer, record)
+		case reply := <-b.flushes:
+			b.r.mu.Lock()
+			b.r.records[b.mType] = append(b.r.records[b.mType], b.buffer...)
+			b.r.mu.Unlock()
+			b.buffer = nil
+			reply <- nil
+		case <-b.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (b *bufferingBackend) Flush() error {
+	reply := make(chan error)
+	select {
+	case b.flushes <- reply:
+		return <-reply
+	case <-b.ctx.Done():
+		return nil
+	}
+}
+
+func TestAckAfterFlush(t *testing.T) {
+
+	r := newRecorder()
+	newBackend := func(ctx context.Context, run persistence.Run, mType measurements.MeasurementType, values <-chan measurements.Measurement) (persistence.Backend, error) {
+		return &bufferingBackend{recorderBackend: recorderBackend{ctx, r, mType, values}, flushes: make(chan chan error)}, nil
+	}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewServer(newBackend, r.writeRun, nil).handle(ctx, conn)
+
+	run := persistence.Run{ID: uuid.NewString(), Host: "host-3"}
+	go func() {
+		writeFrame(client, Frame{Kind: hello, Run: run.ID, Info: &run})
+		for i := 1; i <= 3; i++ {
+			writeFrame(client, Frame{Kind: data, Run: run.ID, Seq: uint64(i), Type: measurements.CPU, Record: record(measurements.CPU, i)})
+		}
+	}()
+
+	frame, err := readFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Kind != ack || frame.Seq != 3 {
+		t.Fatalf("expected an acknowledgement of all 3 frames, got %+v", frame)
+	}
+
+	// the frames are only acknowledged once the backend has written them
+	if n := r.count(measurements.CPU); n != 3 {
+		t.Errorf("expected 3 written records when acknowledged, got %d", n)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+
+	r := newRecorder()
+	s := NewServer(r.newBackend, r.writeRun, r.readRun)
+	s.SetRunTimeout(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a run restored from the spool of a crashed agent never ends
+	run := persistence.Run{ID: uuid.NewString(), Host: "host-4"}
+	err := s.register(ctx, Frame{Kind: hello, Run: run.ID, Info: &run})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.receive(Frame{Kind: data, Run: run.ID, Seq: 1, Type: measurements.CPU, Record: record(measurements.CPU, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expire(time.Now())
+	if len(r.runs) != 1 {
+		t.Fatalf("expected the run to keep going, got %+v", r.runs)
+	}
+
+	s.expire(time.Now().Add(2 * time.Minute))
+	if len(r.runs) != 2 || r.runs[1].StopReason != "timeout" {
+		t.Fatalf("expected the run to time out, got %+v", r.runs)
+	}
+
+	// the agent is back, the run continues
+	_, err = s.receive(Frame{Kind: data, Run: run.ID, Seq: 2, Type: measurements.CPU, Record: record(measurements.CPU, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.runs) != 3 || r.runs[2].StopReason != "" {
+		t.Fatalf("expected the run to be resumed, got %+v", r.runs)
+	}
+
+	s.expire(time.Now().Add(2 * time.Minute))
+	if n := r.count(measurements.CPU); n != 2 {
+		t.Errorf("expected 2 records, got %d", n)
+	}
+}
+
+func TestRejectedRecords(t *testing.T) {
+
+	cpu := func(change func(record []string) []string) []string {
+		return change(record(measurements.CPU, 1))
+	}
+
+	for name, frame := range map[string]Frame{
+		"too few values":  {Type: measurements.CPU, Record: cpu(func(r []string) []string { return r[1:] })},
+		"too many values": {Type: measurements.CPU, Record: cpu(func(r []string) []string { return append(r, "1") })},
+		"sql in a number": {Type: measurements.CPU, Record: cpu(func(r []string) []string {
+			r[len(r)-1] = "10); CREATE TABLE pwned(x); SELECT (1"
+			return r
+		})},
+		"sql in text": {Type: measurements.NET, Record: func() []string {
+			r := record(measurements.NET, 1)
+			r[column(t, measurements.NET, "name")] = "'eth0'); DROP TABLE network; --'"
+			return r
+		}()},
+		"unquoted text": {Type: measurements.NET, Record: func() []string {
+			r := record(measurements.NET, 1)
+			r[column(t, measurements.NET, "name")] = "eth0"
+			return r
+		}()},
+		"unknown type":  {Type: 55, Record: []string{"1"}},
+		"internal type": {Type: measurements.GAP, Record: record(measurements.GAP, 1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+
+			r := newRecorder()
+			client, conn := net.Pipe()
+			defer client.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go NewServer(r.newBackend, r.writeRun, r.readRun).handle(ctx, conn)
+
+			run := persistence.Run{ID: uuid.NewString(), Host: "host-5"}
+			frame.Kind, frame.Run, frame.Seq = data, run.ID, 1
+			go func() {
+				writeFrame(client, Frame{Kind: hello, Run: run.ID, Info: &run})
+				writeFrame(client, frame)
+			}()
+
+			// the server closes the connection instead of acknowledging the frame
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			received, err := readFrame(client)
+			if err == nil {
+				t.Fatalf("expected the connection to be closed, got %+v", received)
+			}
+			if n := r.count(frame.Type); n != 0 {
+				t.Errorf("expected no records, got %d", n)
+			}
+		})
+	}
+}
+
+// column returns the index of a column of a measurement type
+func column(t *testing.T, mType measurements.MeasurementType, name string) int {
+	t.Helper()
+	names, _ := measurements.GetColumnNames(mType)
+	i := slices.Index(names, name)
+	if i < 0 {
+		t.Fatalf("no column %s", name)
+	}
+	return i
+}
+
+func TestRejectedRuns(t *testing.T) {
+
+	for name, run := range map[string]persistence.Run{
+		"host outside the directory": {ID: uuid.NewString(), Host: "../../etc"},
+		"host with a slash":          {ID: uuid.NewString(), Host: "a/b"},
+		"parent host":                {ID: uuid.NewString(), Host: ".."},
+		"current host":               {ID: uuid.NewString(), Host: "."},
+		"no host":                    {ID: uuid.NewString()},
+		"id outside the directory":   {ID: "../../../tmp/x", Host: "host-6"},
+		"id that isn't a uuid":       {ID: "run-6", Host: "host-6"},
+	} {
+		t.Run(name, func(t *testing.T) {
+
+			r := newRecorder()
+			client, conn := net.Pipe()
+			defer client.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go NewServer(r.newBackend, r.writeRun, r.readRun).handle(ctx, conn)
+
+			go writeFrame(client, Frame{Kind: hello, Run: run.ID, Info: &run})
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err := readFrame(client)
+			if err == nil {
+				t.Fatal("expected the connection to be closed")
+			}
+			if len(r.runs) != 0 {
+				t.Errorf("expected no run metadata to be written, got %+v", r.runs)
+			}
+		})
+	}
+}
+
+func TestForgetRuns(t *testing.T) {
+
+	r := newRecorder()
+	s := NewServer(r.newBackend, r.writeRun, r.readRun)
+	s.SetRunTimeout(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	run := persistence.Run{ID: uuid.NewString(), Host: "host-7"}
+	hello := Frame{Kind: hello, Run: run.ID, Info: &run}
+	sent := Frame{Kind: data, Run: run.ID, Seq: 1, Type: measurements.CPU, Record: record(measurements.CPU, 1)}
+
+	err := s.register(ctx, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.receive(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := run
+	ended.StopReason = "duration"
+	_, err = s.receive(Frame{Kind: end, Run: run.ID, Seq: 2, Info: &ended})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expire(time.Now().Add(2 * time.Minute))
+	if len(s.runs) != 0 {
+		t.Fatalf("expected the ended run to be forgotten, got %d runs", len(s.runs))
+	}
+
+	// an agent that didn't get the acknowledgements sends the run again, it's recognized from the stored metadata
+	written := len(r.runs)
+	err = s.register(ctx, hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.receive(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.count(measurements.CPU); n != 1 {
+		t.Errorf("expected the re-sent record to be dropped, got %d records", n)
+	}
+	if len(r.runs) != written {
+		t.Errorf("expected the run's metadata to stay as it is, got %+v", r.runs[written:])
+	}
+}
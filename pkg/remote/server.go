@@ -0,0 +1,459 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valentin-carl/stattrack/pkg/measurements"
+	"github.com/valentin-carl/stattrack/pkg/persistence"
+)
+
+// NewBackend creates the backend that stores the measurements of one type of a run
+type NewBackend func(ctx context.Context, run persistence.Run, mType measurements.MeasurementType, values <-chan measurements.Measurement) (persistence.Backend, error)
+
+// WriteRun stores a run's metadata, it's called when a run starts and when it ends
+type WriteRun func(run persistence.Run) error
+
+// ReadRun returns the stored metadata of a run, it's used to recognize runs that ended before the server forgot them
+type ReadRun func(run persistence.Run) (persistence.Run, error)
+
+const (
+	ackInterval       = time.Second // how often the backends are flushed to acknowledge what they've written
+	expireInterval    = time.Minute // how often runs are checked for a timeout
+	defaultRunTimeout = time.Hour
+	timeoutReason     = "timeout" // stop reason of the runs that timed out
+)
+
+// remoteRun is a run received from an agent
+type remoteRun struct {
+	mu       sync.Mutex // agents can reconnect while their old connection is still open
+	info     persistence.Run
+	seq      uint64 // the last data frame handed to a backend
+	lastSeen time.Time
+	ended    bool
+	endedAt  time.Time
+	timedOut bool            // ended because the agent went silent, it's resumed if the agent comes back
+	parent   context.Context // the server's context
+	ctx      context.Context // stops the run's backends
+	cancel   context.CancelFunc
+	channels map[measurements.MeasurementType]chan measurements.Measurement // nil for types without a backend
+	flushers []persistence.Flusher
+	backends sync.WaitGroup
+}
+
+// Server receives the runs of many agents and writes them into backends
+type Server struct {
+	newBackend NewBackend
+	writeRun   WriteRun
+	readRun    ReadRun
+	runTimeout time.Duration
+
+	mu   sync.Mutex
+	runs map[string]*remoteRun // by ID, ended runs are kept for the run timeout to recognize duplicates
+}
+
+// NewServer creates a server writing runs with the given functions, `readRun` may be nil
+func NewServer(newBackend NewBackend, writeRun WriteRun, readRun ReadRun) *Server {
+	return &Server{
+		newBackend: newBackend,
+		writeRun:   writeRun,
+		readRun:    readRun,
+		runTimeout: defaultRunTimeout,
+		runs:       make(map[string]*remoteRun),
+	}
+}
+
+// SetRunTimeout sets how long a run may go without frames before it's ended, e.g., because its agent crashed
+// and the run was restored from the spool without its end. Ended runs are forgotten after the same time.
+// A timeout that isn't positive keeps runs forever. It has to be called before Serve.
+func (s *Server) SetRunTimeout(timeout time.Duration) {
+	s.runTimeout = timeout
+}
+
+// Serve accepts agents on `listener` until the context is cancelled, then the backends of all runs are stopped
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+
+	slog.Info("server starting", "addr", listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	// runs whose agent went silent are ended alongside
+	var expiring sync.WaitGroup
+	if s.runTimeout > 0 {
+		expiring.Add(1)
+		go func() {
+			defer expiring.Done()
+			ticker := time.NewTicker(expireInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					s.expire(now)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var conns sync.WaitGroup
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			slog.Error("could not accept connection", "err", err)
+			continue
+		}
+
+		conns.Add(1)
+		go func() {
+			s.handle(ctx, conn)
+			conns.Done()
+		}()
+	}
+
+	conns.Wait()
+	expiring.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		run.mu.Lock()
+		s.finish(run)
+		run.mu.Unlock()
+	}
+
+	slog.Info("server done")
+
+	return nil
+}
+
+// handle reads the frames of one agent connection
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+
+	logger := slog.With("agent", conn.RemoteAddr())
+	logger.Info("agent connected")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	// acknowledgements are written by the reading loop and the goroutine acknowledging the written data frames
+	var writing sync.Mutex
+	acknowledge := func(run string, seq uint64) error {
+		writing.Lock()
+		defer writing.Unlock()
+		return writeFrame(conn, Frame{Kind: ack, Run: run, Seq: seq})
+	}
+
+	// the runs with data frames that are received but not acknowledged yet
+	var pendingMu sync.Mutex
+	pending := make(map[string]*remoteRun)
+
+	go func() {
+		ticker := time.NewTicker(ackInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			pendingMu.Lock()
+			runs := pending
+			pending = make(map[string]*remoteRun)
+			pendingMu.Unlock()
+
+			for id, run := range runs {
+				seq, err := s.persist(run)
+				if err != nil {
+					logger.Error("could not flush backends, not acknowledging yet", "run", id, "err", err)
+					pendingMu.Lock()
+					pending[id] = run
+					pendingMu.Unlock()
+					continue
+				}
+				err = acknowledge(id, seq)
+				if err != nil {
+					return // the reading loop notices, too
+				}
+			}
+		}
+	}()
+
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Info("agent disconnected", "err", err)
+			}
+			return
+		}
+
+		var run *remoteRun
+		if frame.Kind == hello {
+			err = s.register(ctx, frame)
+		} else {
+			run, err = s.receive(frame)
+		}
+		if err != nil {
+			logger.Error("invalid frame, closing connection", "kind", frame.Kind, "run", frame.Run, "err", err)
+			return
+		}
+
+		switch frame.Kind {
+		case data:
+			// data frames are acknowledged once the backends have written them
+			pendingMu.Lock()
+			pending[frame.Run] = run
+			pendingMu.Unlock()
+		case end:
+			// ending the run has stopped its backends, so everything is written
+			err = acknowledge(frame.Run, frame.Seq)
+			if err != nil {
+				logger.Info("agent disconnected", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// register starts receiving a run announced by a hello frame, a known run is continued
+func (s *Server) register(ctx context.Context, frame Frame) error {
+
+	if frame.Info == nil || frame.Info.ID != frame.Run {
+		return errors.New("hello frame without the run's metadata")
+	}
+	err := checkRun(*frame.Info)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.runs[frame.Run]; ok {
+		return nil
+	}
+
+	run := &remoteRun{
+		info:     *frame.Info,
+		lastSeen: time.Now(),
+		parent:   ctx,
+		channels: make(map[measurements.MeasurementType]chan measurements.Measurement),
+	}
+	run.ctx, run.cancel = context.WithCancel(ctx)
+	s.runs[frame.Run] = run
+
+	// a run that has ended before the server forgot it only gets its re-sent frames acknowledged
+	if s.readRun != nil {
+		stored, err := s.readRun(run.info)
+		if err == nil && stored.StopReason != "" {
+			slog.Info("run has already ended", "run", run.info.ID, "host", run.info.Host, "reason", stored.StopReason)
+			run.info = stored
+			run.ended, run.endedAt = true, time.Now()
+			run.timedOut = stored.StopReason == timeoutReason
+			run.cancel()
+			return nil
+		}
+	}
+
+	slog.Info("receiving run", "run", run.info.ID, "host", run.info.Host)
+	s.store(run.info)
+
+	return nil
+}
+
+// receive hands a data frame to the backend of its type or ends a run
+func (s *Server) receive(frame Frame) (*remoteRun, error) {
+
+	s.mu.Lock()
+	run, ok := s.runs[frame.Run]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown run %s", frame.Run)
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	run.lastSeen = time.Now()
+
+	// the agent of a timed out run is back
+	if run.timedOut && frame.Seq > run.seq {
+		s.resume(run)
+	}
+
+	// frames are resent if the agent didn't get the acknowledgement
+	if frame.Seq <= run.seq || run.ended {
+		return run, nil
+	}
+
+	switch frame.Kind {
+	case data:
+		err := checkRecord(frame)
+		if err != nil {
+			return nil, err
+		}
+		s.write(run, frame)
+	case end:
+		if frame.Info != nil {
+			run.info = *frame.Info
+		}
+		s.finish(run)
+		slog.Info("run ended", "run", run.info.ID, "host", run.info.Host, "reason", run.info.StopReason)
+	default:
+		return nil, fmt.Errorf("unknown kind of frame %q", frame.Kind)
+	}
+
+	run.seq = frame.Seq
+
+	return run, nil
+}
+
+// persist waits until the backends of a run have written what they've received
+// and returns the last data frame that has been written
+func (s *Server) persist(run *remoteRun) (uint64, error) {
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	// the backends of an ended run have written everything before they stopped
+	if run.ended {
+		return run.seq, nil
+	}
+
+	for _, flusher := range run.flushers {
+		err := flusher.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return run.seq, nil
+}
+
+// expire ends the runs that haven't received a frame for longer than the run timeout
+// and forgets the runs that have ended longer ago than that
+func (s *Server) expire(now time.Time) {
+
+	s.mu.Lock()
+	runs := make([]*remoteRun, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, run)
+	}
+	s.mu.Unlock()
+
+	var forgotten []string
+	for _, run := range runs {
+		run.mu.Lock()
+		switch {
+		case !run.ended && now.Sub(run.lastSeen) > s.runTimeout:
+			slog.Warn("no frames received in time, ending run", "run", run.info.ID, "host", run.info.Host, "since", run.lastSeen)
+			run.info.StopReason = timeoutReason
+			run.timedOut = true
+			s.finish(run)
+		case run.ended && now.Sub(run.endedAt) > s.runTimeout && now.Sub(run.lastSeen) > s.runTimeout:
+			forgotten = append(forgotten, run.info.ID)
+		}
+		run.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range forgotten {
+		slog.Debug("forgetting run", "run", id)
+		delete(s.runs, id)
+	}
+}
+
+// resume continues a run that has timed out with new backends.
+// The caller holds the run's lock.
+func (s *Server) resume(run *remoteRun) {
+
+	slog.Info("resuming run", "run", run.info.ID, "host", run.info.Host)
+
+	run.ended, run.timedOut = false, false
+	run.info.StopReason = ""
+	run.ctx, run.cancel = context.WithCancel(run.parent)
+	run.channels = make(map[measurements.MeasurementType]chan measurements.Measurement)
+	run.flushers = nil
+
+	s.store(run.info)
+}
+
+// write sends a measurement to its backend, which is created on the type's first measurement.
+// The caller holds the run's lock.
+func (s *Server) write(run *remoteRun, frame Frame) {
+
+	values, ok := run.channels[frame.Type]
+	if !ok {
+		values = make(chan measurements.Measurement)
+		backend, err := s.newBackend(run.ctx, run.info, frame.Type, values)
+		if err != nil {
+			slog.Error("cannot create backend, dropping this type", "run", run.info.ID, "type", frame.Type, "err", err)
+			values = nil
+		} else {
+			if flusher, ok := backend.(persistence.Flusher); ok {
+				run.flushers = append(run.flushers, flusher)
+			}
+			run.backends.Add(1)
+			go func() {
+				backend.Start()
+				run.backends.Done()
+			}()
+		}
+		run.channels[frame.Type] = values
+	}
+	if values == nil {
+		return
+	}
+
+	select {
+	case values <- measurements.Row{Type: frame.Type, Values: frame.Record}:
+	case <-run.ctx.Done():
+	}
+}
+
+// finish stops the backends of a run and stores its metadata.
+// The caller holds the run's lock.
+func (s *Server) finish(run *remoteRun) {
+
+	if run.ended {
+		return
+	}
+	run.ended, run.endedAt = true, time.Now()
+
+	run.cancel()
+	run.backends.Wait()
+
+	s.store(run.info)
+}
+
+func (s *Server) store(run persistence.Run) {
+	if s.writeRun == nil {
+		return
+	}
+	err := s.writeRun(run)
+	if err != nil {
+		slog.Error("could not write run metadata", "run", run.ID, "err", err)
+	}
+}
@@ -0,0 +1,126 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const spoolExtension = ".spool"
+
+// spool keeps the frames an agent couldn't deliver on disk, one file of JSON lines per run.
+// Every file starts with the run's hello frame, so runs of earlier agents can be sent later, too.
+type spool struct {
+	dir string
+}
+
+func newSpool(dir string) (spool, error) {
+	return spool{dir}, os.MkdirAll(dir, fs.ModePerm)
+}
+
+func (s spool) file(run string) string {
+	return filepath.Join(s.dir, run+spoolExtension)
+}
+
+// add appends frames to the files of their runs, `hellos` are the hello frames by run
+func (s spool) add(frames []Frame, hellos map[string]Frame) error {
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, frame := range frames {
+
+		f, ok := files[frame.Run]
+		if !ok {
+			var err error
+			f, err = os.OpenFile(s.file(frame.Run), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			files[frame.Run] = f
+
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				hello, ok := hellos[frame.Run]
+				if !ok {
+					return fmt.Errorf("no hello frame for run %s", frame.Run)
+				}
+				err = json.NewEncoder(f).Encode(hello)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err := json.NewEncoder(f).Encode(frame)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runs lists the runs with spooled frames
+func (s spool) runs() ([]string, error) {
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []string
+	for _, entry := range entries {
+		if run, ok := strings.CutSuffix(entry.Name(), spoolExtension); ok && !entry.IsDir() {
+			runs = append(runs, run)
+		}
+	}
+	slices.Sort(runs)
+
+	return runs, nil
+}
+
+// take reads and removes the spooled frames of a run
+func (s spool) take(run string) ([]Frame, error) {
+
+	f, err := os.Open(s.file(run))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frames []Frame
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxFrameSize)
+	for scanner.Scan() {
+		var frame Frame
+		err = json.Unmarshal(scanner.Bytes(), &frame)
+		if err != nil {
+			// e.g., the last line of an agent that was killed while writing it
+			break
+		}
+		frames = append(frames, frame)
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+
+	return frames, os.Remove(s.file(run))
+}